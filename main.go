@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kraudcloud/wga/operator"
 	"github.com/spf13/cobra"
@@ -33,6 +34,12 @@ func main() {
 	}
 	rootCmd.PersistentFlags().CountVarP(&vCount, "verbose", "v", "log level")
 
+	backend := os.Getenv("WGA_WG_BACKEND")
+	if backend == "" {
+		backend = operator.BackendKernel
+	}
+	dryRun := false
+
 	serverCmd := &cobra.Command{
 		Use:   "ep [name]",
 		Short: "run named WireguardAccessEndpoint",
@@ -81,18 +88,67 @@ func main() {
 			}
 			dnsServers := strings.Split(DNSServers, ",")
 
-			operator.RunWGA(cmd.Context(), clientConfig(), serviceNets, peersNets, dnsServers, serverAddr)
+			policy := operator.PeerPolicy{}
+			if grace := os.Getenv("WGA_EXPIRED_PEER_GRACE"); grace != "" {
+				d, err := time.ParseDuration(grace)
+				if err != nil {
+					slog.Error("cannot parse expired peer grace period", "WGA_EXPIRED_PEER_GRACE", grace, "err", err.Error())
+					os.Exit(1)
+				}
+				policy.ExpiredGrace = d
+			}
+
+			if threshold := os.Getenv("WGA_IDLE_PEER_THRESHOLD"); threshold != "" {
+				d, err := time.ParseDuration(threshold)
+				if err != nil {
+					slog.Error("cannot parse idle peer threshold", "WGA_IDLE_PEER_THRESHOLD", threshold, "err", err.Error())
+					os.Exit(1)
+				}
+				policy.IdleThreshold = d
+			}
+
+			action, err := operator.ParseIdleAction(os.Getenv("WGA_IDLE_PEER_ACTION"))
+			if err != nil {
+				slog.Error("cannot parse idle peer action", "WGA_IDLE_PEER_ACTION", os.Getenv("WGA_IDLE_PEER_ACTION"), "err", err.Error())
+				os.Exit(1)
+			}
+			policy.IdleAction = action
+
+			policy.IdleGrace = operator.DefaultIdleGrace
+			if grace := os.Getenv("WGA_IDLE_PEER_GRACE"); grace != "" {
+				d, err := time.ParseDuration(grace)
+				if err != nil {
+					slog.Error("cannot parse idle peer grace period", "WGA_IDLE_PEER_GRACE", grace, "err", err.Error())
+					os.Exit(1)
+				}
+				policy.IdleGrace = d
+			}
+
+			if interval := os.Getenv("WGA_PSK_ROTATION_INTERVAL"); interval != "" {
+				d, err := time.ParseDuration(interval)
+				if err != nil {
+					slog.Error("cannot parse psk rotation interval", "WGA_PSK_ROTATION_INTERVAL", interval, "err", err.Error())
+					os.Exit(1)
+				}
+				policy.PSKRotationInterval = d
+			}
+
+			operator.RunWGA(cmd.Context(), clientConfig(), dataplane(backend, dryRun), dryRun, serviceNets, peersNets, dnsServers, serverAddr, policy)
 		},
 	}
+	serverCmd.Flags().StringVar(&backend, "wg-backend", backend, "wireguard implementation to use: kernel or userspace")
+	serverCmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "log dataplane and api changes instead of applying them, api writes are sent as server side dry runs")
 	rootCmd.AddCommand(serverCmd)
 
 	wgcCmd := &cobra.Command{
 		Use:   "clusterclient",
 		Short: "run ClusterClient",
 		Run: func(cmd *cobra.Command, args []string) {
-			operator.RunWGC(cmd.Context(), clientConfig())
+			operator.RunWGC(cmd.Context(), clientConfig(), dataplane(backend, dryRun), dryRun)
 		},
 	}
+	wgcCmd.Flags().StringVar(&backend, "wg-backend", backend, "wireguard implementation to use: kernel or userspace")
+	wgcCmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "log dataplane and api changes instead of applying them, api writes are sent as server side dry runs")
 	rootCmd.AddCommand(wgcCmd)
 
 	rootCmd.AddCommand(peerCmd())
@@ -103,6 +159,16 @@ func main() {
 	}
 }
 
+func dataplane(backend string, dryRun bool) operator.Dataplane {
+	dp, err := operator.NewDataplane(backend, dryRun, slog.Default())
+	if err != nil {
+		slog.Error("cannot set up dataplane", "err", err.Error())
+		os.Exit(1)
+	}
+
+	return dp
+}
+
 // clientConfig loads the config either from kubeconfig or falls back to the cluster
 // the k8s client has a similar function but it logs stuff when trying to fallback.
 func clientConfig() *rest.Config {
@@ -126,3 +192,27 @@ func clientConfig() *rest.Config {
 
 	return c
 }
+
+// clientNamespace is the namespace of the kubeconfig's current context, like kubectl's default namespace,
+// or the pod's namespace when running in the cluster. CLI commands use it for the endpoint's namespace unless told otherwise.
+func clientNamespace() string {
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		ns, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}, &clientcmd.ConfigOverrides{},
+		).Namespace()
+		if err != nil {
+			slog.Error("cannot load kubeconfig", "kubeconfig", kubeconfig, "err", err.Error())
+			os.Exit(1)
+		}
+
+		return ns
+	}
+
+	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		slog.Error("cannot read in-cluster namespace", "err", err.Error())
+		os.Exit(1)
+	}
+
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,158 @@
+package operator
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const (
+	BackendKernel    = "kernel"
+	BackendUserspace = "userspace"
+)
+
+// LinkBackend creates and removes wireguard interfaces.
+// Once a link exists it is configured through wgctrl and netlink regardless of the backend.
+type LinkBackend interface {
+	// AddLink creates a wireguard interface with the given name.
+	AddLink(name string) error
+	// DelLink removes the interface if it exists.
+	DelLink(name string) error
+	// LinkType is the netlink link type of interfaces created by this backend.
+	LinkType() string
+}
+
+// NewBackend returns the LinkBackend for the given name.
+func NewBackend(name string) (LinkBackend, error) {
+	switch name {
+	case "", BackendKernel:
+		return &KernelBackend{}, nil
+	case BackendUserspace:
+		return NewUserspaceBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown wireguard backend %q, expected %s or %s", name, BackendKernel, BackendUserspace)
+	}
+}
+
+// KernelBackend uses the wireguard kernel module.
+type KernelBackend struct{}
+
+func (b *KernelBackend) AddLink(name string) error {
+	return netlink.LinkAdd(&netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: name,
+		},
+		LinkType: "wireguard",
+	})
+}
+
+func (b *KernelBackend) DelLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil
+	}
+
+	return netlink.LinkDel(link)
+}
+
+func (b *KernelBackend) LinkType() string {
+	return "wireguard"
+}
+
+// UserspaceBackend runs wireguard-go on a TUN device inside this process.
+// The device is exposed on the UAPI socket in /var/run/wireguard, where wgctrl finds it like a kernel device.
+type UserspaceBackend struct {
+	lock    sync.Mutex
+	devices map[string]*userspaceDevice
+}
+
+type userspaceDevice struct {
+	device *device.Device
+	uapi   net.Listener
+}
+
+func NewUserspaceBackend() *UserspaceBackend {
+	return &UserspaceBackend{
+		devices: map[string]*userspaceDevice{},
+	}
+}
+
+func (b *UserspaceBackend) AddLink(name string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.devices[name]; ok {
+		return fmt.Errorf("device %s already exists", name)
+	}
+
+	tdev, err := tun.CreateTUN(name, device.DefaultMTU)
+	if err != nil {
+		return fmt.Errorf("cannot create tun %s: %w", name, err)
+	}
+
+	dev := device.NewDevice(tdev, conn.NewDefaultBind(), device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", name)))
+
+	fileUAPI, err := ipc.UAPIOpen(name)
+	if err != nil {
+		dev.Close()
+		return fmt.Errorf("cannot open uapi socket for %s: %w", name, err)
+	}
+
+	uapi, err := ipc.UAPIListen(name, fileUAPI)
+	if err != nil {
+		fileUAPI.Close()
+		dev.Close()
+		return fmt.Errorf("cannot listen on uapi socket for %s: %w", name, err)
+	}
+
+	go func() {
+		for {
+			c, err := uapi.Accept()
+			if err != nil {
+				return
+			}
+			go dev.IpcHandle(c)
+		}
+	}()
+
+	b.devices[name] = &userspaceDevice{
+		device: dev,
+		uapi:   uapi,
+	}
+
+	slog.Info("created userspace wg", "interface", name)
+
+	return nil
+}
+
+func (b *UserspaceBackend) DelLink(name string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	d, ok := b.devices[name]
+	if !ok {
+		// left over from a previous process, the tun is gone with it unless someone else owns it
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return nil
+		}
+		return netlink.LinkDel(link)
+	}
+
+	d.uapi.Close()
+	d.device.Close()
+	delete(b.devices, name)
+
+	return nil
+}
+
+func (b *UserspaceBackend) LinkType() string {
+	return "tuntap"
+}
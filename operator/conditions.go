@@ -0,0 +1,22 @@
+package operator
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setCondition sets a condition observed at the given generation and reports whether it changed.
+func setCondition(conds *[]metav1.Condition, generation int64, typ string, status bool, reason, message string) bool {
+	s := metav1.ConditionFalse
+	if status {
+		s = metav1.ConditionTrue
+	}
+
+	return meta.SetStatusCondition(conds, metav1.Condition{
+		Type:               typ,
+		Status:             s,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
@@ -0,0 +1,104 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ErrNoChain is returned by Dataplane.FilterRules when the device has no filter chain yet.
+var ErrNoChain = errors.New("filter chain does not exist")
+
+// Dataplane is everything the endpoint and the cluster client change on the node:
+// wireguard devices, their addresses and routes, and the filter rules in front of them.
+//
+// KernelDataplane applies changes to the host, MemoryDataplane records them for tests and --dry-run.
+type Dataplane interface {
+	// AddLink creates a wireguard interface.
+	AddLink(name string) error
+	// DelLink removes a wireguard interface if it exists.
+	DelLink(name string) error
+	// Links lists the wireguard interfaces whose name starts with prefix.
+	Links(prefix string) ([]string, error)
+	// LinkUp brings an interface up.
+	LinkUp(name string) error
+
+	// Device returns the wireguard configuration and session state of an interface.
+	Device(name string) (*wgtypes.Device, error)
+	// ConfigureDevice applies a wireguard configuration to an interface.
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+
+	Addrs(name string) ([]net.IPNet, error)
+	AddrReplace(name string, addr net.IPNet) error
+	AddrDel(name string, addr net.IPNet) error
+
+	// Routes lists the destinations routed into an interface.
+	Routes(name string) ([]net.IPNet, error)
+	RouteReplace(name string, dst net.IPNet) error
+	RouteDel(name string, dst net.IPNet) error
+
+	// EnsureMasquerade masquerades all traffic leaving through oifname.
+	EnsureMasquerade(ctx context.Context, oifname string) error
+	// EnsureFilterChain creates the ingress chain of a device, dropping everything not accepted by a rule.
+	EnsureFilterChain(ctx context.Context, device string) error
+	FilterRules(ctx context.Context, device string) ([]FilterRule, error)
+	AddFilterRule(ctx context.Context, device string, rule FilterRule) error
+	DelFilterRule(ctx context.Context, device string, rule FilterRule) error
+
+	Sysctl(ctx context.Context, key string) (string, error)
+	SetSysctl(ctx context.Context, key, value string) error
+}
+
+// FilterRule accepts traffic from Source to Destination entering a device.
+// Rules are identified by their Comment, rules read back from the kernel only carry Comment and Handle.
+type FilterRule struct {
+	Comment     string
+	Source      net.IPNet
+	Destination string
+	// Protocol and Ports optionally restrict the rule, eg. "udp" and "53" or "tcp" and "{ 80, 443 }".
+	Protocol string
+	Ports    string
+
+	Handle uint64
+}
+
+func (r FilterRule) String() string {
+	s := fmt.Sprintf("saddr %s daddr %s", r.Source.String(), r.Destination)
+	if r.Protocol != "" {
+		s += fmt.Sprintf(" %s dport %s", r.Protocol, r.Ports)
+	}
+	return s + " comment " + r.Comment
+}
+
+// family is the nft address family matching the rule's source.
+func (r FilterRule) family() string {
+	if r.Source.IP.To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
+
+// NewDataplane returns the dataplane for the given wireguard backend.
+// With dryRun, changes are logged and kept in memory instead.
+func NewDataplane(backend string, dryRun bool, log *slog.Logger) (Dataplane, error) {
+	if dryRun {
+		return NewMemoryDataplane(log.With("component", "dry-run")), nil
+	}
+
+	b, err := NewBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKernelDataplane(b), nil
+}
+
+func ipNetsEqual(a, b net.IPNet) bool {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+	return a.IP.Equal(b.IP) && aOnes == bOnes && aBits == bBits
+}
@@ -0,0 +1,91 @@
+package operator
+
+import (
+	"context"
+	"log/slog"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newDryRunClient wraps c for --dry-run. Every write is logged and sent as a server side dry run,
+// so it is validated by the api server but never persisted: no finalizers, statuses, key secrets,
+// rotated psks or deleted peers end up in the cluster.
+func newDryRunClient(c client.Client, log *slog.Logger) client.Client {
+	return &dryRunClient{Client: client.NewDryRunClient(c), log: log}
+}
+
+// dryRunClient logs writes before passing them to a client that only dry runs them.
+type dryRunClient struct {
+	client.Client
+	log *slog.Logger
+}
+
+func (c *dryRunClient) logWrite(verb string, obj client.Object, subResource string) {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := c.Client.GroupVersionKindFor(obj); err == nil {
+		kind = gvk.Kind
+	}
+
+	args := []any{"verb", verb, "kind", kind, "name", obj.GetName()}
+	if obj.GetNamespace() != "" {
+		args = append(args, "namespace", obj.GetNamespace())
+	}
+	if subResource != "" {
+		args = append(args, "subresource", subResource)
+	}
+	c.log.Info("api change", args...)
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.logWrite("create", obj, "")
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.logWrite("update", obj, "")
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.logWrite("patch", obj, "")
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.logWrite("delete", obj, "")
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	c.logWrite("deletecollection", obj, "")
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *dryRunClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *dryRunClient) SubResource(subResource string) client.SubResourceClient {
+	return &dryRunSubResourceClient{SubResourceClient: c.Client.SubResource(subResource), parent: c, name: subResource}
+}
+
+type dryRunSubResourceClient struct {
+	client.SubResourceClient
+	parent *dryRunClient
+	name   string
+}
+
+func (c *dryRunSubResourceClient) Create(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	c.parent.logWrite("create", obj, c.name)
+	return c.SubResourceClient.Create(ctx, obj, subResource, opts...)
+}
+
+func (c *dryRunSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	c.parent.logWrite("update", obj, c.name)
+	return c.SubResourceClient.Update(ctx, obj, opts...)
+}
+
+func (c *dryRunSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	c.parent.logWrite("patch", obj, c.name)
+	return c.SubResourceClient.Patch(ctx, obj, patch, opts...)
+}
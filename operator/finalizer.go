@@ -0,0 +1,71 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// FinalizerDataplane holds back the deletion of a peer until the endpoint removed it from the device,
+// so a peer that is gone from the api is also gone from the vpn.
+// Without a running endpoint it has to be removed by hand, see the README.
+const FinalizerDataplane = "wga.kraudcloud.com/dataplane"
+
+// addFinalizer makes sure the endpoint gets to remove the peer from the device before it is deleted.
+func (r *PeerReconciler) addFinalizer(ctx context.Context, peer *v1beta.WireguardAccessPeer) error {
+	if controllerutil.ContainsFinalizer(peer, FinalizerDataplane) {
+		return nil
+	}
+
+	// the peer comes from the cache, the lock keeps finalizers added since then
+	patch := client.MergeFromWithOptions(peer.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	controllerutil.AddFinalizer(peer, FinalizerDataplane)
+
+	return r.client.Patch(ctx, peer, patch)
+}
+
+// finalize removes a deleted peer from the device and then lets the deletion complete.
+// Fetch leaves out peers being deleted, any sync after the deletion removes them.
+func (r *PeerReconciler) finalize(ctx context.Context, peer *v1beta.WireguardAccessPeer) error {
+	if !controllerutil.ContainsFinalizer(peer, FinalizerDataplane) {
+		r.forgetSpec(peer.Name)
+		return nil
+	}
+
+	present, _, err := r.onDevice(peer)
+	if err != nil {
+		return err
+	}
+
+	if present {
+		err = r.syncer.Sync(ctx)
+		if err != nil {
+			return err
+		}
+
+		present, _, err = r.onDevice(peer)
+		if err != nil {
+			return err
+		}
+		if present {
+			// eg. another peer with the same key
+			return fmt.Errorf("peer %s still on the device after sync", peer.Name)
+		}
+	}
+
+	r.log.Info("removed deleted peer from the device", "peer", peer.Name)
+
+	patch := client.MergeFromWithOptions(peer.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	controllerutil.RemoveFinalizer(peer, FinalizerDataplane)
+
+	err = r.client.Patch(ctx, peer, patch)
+	if err != nil {
+		return err
+	}
+
+	r.forgetSpec(peer.Name)
+	return nil
+}
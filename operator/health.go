@@ -0,0 +1,164 @@
+package operator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// DefaultHealthAddress is where the manager serves /healthz and /readyz unless WGA_HEALTH_ADDRESS is set.
+	DefaultHealthAddress = ":8081"
+
+	// SyncStaleAfter is how long a failing sync is tolerated before the endpoint reports not ready.
+	SyncStaleAfter = 5 * time.Minute
+)
+
+// lastSync records the outcome of WGASync for the readiness check.
+var lastSync = struct {
+	sync.Mutex
+	success time.Time
+	err     error
+}{}
+
+func recordSync(err error) {
+	lastSync.Lock()
+	defer lastSync.Unlock()
+
+	lastSync.err = err
+	if err == nil {
+		lastSync.success = time.Now()
+	}
+}
+
+func addHealthChecks(mgr manager.Manager, checks map[string]healthz.Checker, readyChecks map[string]healthz.Checker) error {
+	for name, check := range checks {
+		if err := mgr.AddHealthzCheck(name, check); err != nil {
+			return err
+		}
+	}
+
+	for name, check := range readyChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkWGADevice verifies the endpoint device exists and carries the key and port set by wgaInit.
+func checkWGADevice(dp Dataplane) healthz.Checker {
+	return func(_ *http.Request) error {
+		device, err := dp.Device(DEVICENAME)
+		if err != nil {
+			return fmt.Errorf("wg.Device(%s): %w", DEVICENAME, err)
+		}
+
+		if WGConfig.PrivateKey == nil || WGConfig.ListenPort == nil {
+			return errors.New("wg device not initialized")
+		}
+
+		if device.PrivateKey != *WGConfig.PrivateKey {
+			return fmt.Errorf("device %s has unexpected public key %s", DEVICENAME, device.PublicKey)
+		}
+
+		if device.ListenPort != *WGConfig.ListenPort {
+			return fmt.Errorf("device %s listens on %d, expected %d", DEVICENAME, device.ListenPort, *WGConfig.ListenPort)
+		}
+
+		return nil
+	}
+}
+
+// checkNFT verifies the ingress chain of the endpoint device exists.
+func checkNFT(dp Dataplane) healthz.Checker {
+	return func(req *http.Request) error {
+		_, err := dp.FilterRules(req.Context(), DEVICENAME)
+		if err != nil {
+			return fmt.Errorf("nft chain netdev %s %s: %w", nftTable, DEVICENAME, err)
+		}
+
+		return nil
+	}
+}
+
+// checkForwarding verifies the sysctls set by sysctl() are in effect.
+func checkForwarding(dp Dataplane) healthz.Checker {
+	return func(req *http.Request) error {
+		v, err := dp.Sysctl(req.Context(), "net.ipv6.conf.all.forwarding")
+		if err != nil {
+			return fmt.Errorf("cannot read ipv6 forwarding sysctl: %w", err)
+		}
+
+		if v != "1" {
+			return errors.New("net.ipv6.conf.all.forwarding is not enabled")
+		}
+
+		return nil
+	}
+}
+
+// checkLastSync fails until the first successful sync, and when syncs keep failing for longer than SyncStaleAfter.
+func checkLastSync(_ *http.Request) error {
+	lastSync.Lock()
+	defer lastSync.Unlock()
+
+	if lastSync.success.IsZero() {
+		if lastSync.err != nil {
+			return fmt.Errorf("no successful sync yet: %w", lastSync.err)
+		}
+		return errors.New("no successful sync yet")
+	}
+
+	if lastSync.err != nil && time.Since(lastSync.success) > SyncStaleAfter {
+		return fmt.Errorf("last successful sync %s ago: %w", time.Since(lastSync.success).Round(time.Second), lastSync.err)
+	}
+
+	return nil
+}
+
+// wgcExpected holds the private keys of the interfaces configured by the last wgcSync.
+var wgcExpected = struct {
+	sync.Mutex
+	keys map[string]wgtypes.Key
+}{}
+
+func recordWGC(peers []wgPeer) {
+	wgcExpected.Lock()
+	defer wgcExpected.Unlock()
+
+	wgcExpected.keys = make(map[string]wgtypes.Key, len(peers))
+	for _, p := range peers {
+		wgcExpected.keys["wgc-"+p.PeerName] = p.PeerPrivateKey
+	}
+}
+
+// checkWGCDevices verifies that every interface configured by the last sync is still present with its key.
+func checkWGCDevices(dp Dataplane) healthz.Checker {
+	return func(_ *http.Request) error {
+		wgcExpected.Lock()
+		defer wgcExpected.Unlock()
+
+		errs := []error{}
+		for ifname, key := range wgcExpected.keys {
+			device, err := dp.Device(ifname)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("wg.Device(%s): %w", ifname, err))
+				continue
+			}
+
+			if device.PrivateKey != key {
+				errs = append(errs, fmt.Errorf("device %s has unexpected public key %s", ifname, device.PublicKey))
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
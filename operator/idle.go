@@ -0,0 +1,238 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// IdleActionNone only reports idle peers.
+	IdleActionNone = "none"
+	// IdleActionDisable sets spec.disabled on idle peers.
+	IdleActionDisable = "disable"
+	// IdleActionDelete deletes idle peers.
+	IdleActionDelete = "delete"
+
+	// DefaultIdleGrace is the IdleGrace used unless WGA_IDLE_PEER_GRACE is set.
+	DefaultIdleGrace = 24 * time.Hour
+)
+
+// ParseIdleAction validates an idle action, empty means IdleActionNone.
+func ParseIdleAction(s string) (string, error) {
+	switch s {
+	case "", IdleActionNone:
+		return IdleActionNone, nil
+	case IdleActionDisable, IdleActionDelete:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown idle action %q, expected %s, %s or %s", s, IdleActionNone, IdleActionDisable, IdleActionDelete)
+	}
+}
+
+// lastSeen holds the latest handshake of every peer read from the wga device, by public key.
+// wg forgets handshakes when a peer is removed or the endpoint restarts, this does not.
+var lastSeen = struct {
+	sync.Mutex
+	times map[string]time.Time
+}{
+	times: map[string]time.Time{},
+}
+
+func recordHandshakes(peers []wgtypes.Peer) {
+	lastSeen.Lock()
+	defer lastSeen.Unlock()
+
+	for _, p := range peers {
+		if p.LastHandshakeTime.After(lastSeen.times[p.PublicKey.String()]) {
+			lastSeen.times[p.PublicKey.String()] = p.LastHandshakeTime
+		}
+	}
+}
+
+// peerLastSeen is the latest handshake of the peer known to this endpoint or recorded in its status.
+func peerLastSeen(peer *v1beta.WireguardAccessPeer) time.Time {
+	lastSeen.Lock()
+	seen := lastSeen.times[peer.Spec.PublicKey]
+	lastSeen.Unlock()
+
+	if peer.Status != nil && peer.Status.LastSeen != nil && peer.Status.LastSeen.After(seen) {
+		seen = peer.Status.LastSeen.Time
+	}
+
+	return seen
+}
+
+// lastSeenChanged reports whether seen is worth writing to the status.
+// Like connections, handshakes are written at most every StatusMinWriteInterval.
+func lastSeenChanged(old *metav1.Time, seen time.Time) bool {
+	if seen.IsZero() {
+		return false
+	}
+
+	if old == nil {
+		return true
+	}
+
+	return seen.Sub(old.Time) >= StatusMinWriteInterval
+}
+
+// idleSince is when the peer was last known not to be idle: its latest handshake,
+// its creation, or when it was last marked as not idle, eg. after being re-enabled.
+func idleSince(peer *v1beta.WireguardAccessPeer) time.Time {
+	since := peer.CreationTimestamp.Time
+
+	if seen := peerLastSeen(peer); seen.After(since) {
+		since = seen
+	}
+
+	if peer.Status != nil {
+		cond := meta.FindStatusCondition(peer.Status.Conditions, v1beta.ConditionIdle)
+		if cond != nil && cond.Status == metav1.ConditionFalse && cond.LastTransitionTime.After(since) {
+			since = cond.LastTransitionTime.Time
+		}
+	}
+
+	return since
+}
+
+// setIdleCondition updates the Idle condition of a peer and reports whether it changed.
+// Inactive peers can't have handshakes, they are never idle. Once they are active again
+// the idle clock restarts, so a peer disabled for being idle isn't disabled again right away.
+func setIdleCondition(peer *v1beta.WireguardAccessPeer, now time.Time, threshold time.Duration) bool {
+	conds := &peer.Status.Conditions
+	if peerInactive(peer, now) != "" {
+		return setCondition(conds, peer.Generation, v1beta.ConditionIdle, false, "Inactive", "")
+	}
+
+	cond := meta.FindStatusCondition(*conds, v1beta.ConditionIdle)
+	if cond != nil && cond.Reason == "Inactive" {
+		meta.RemoveStatusCondition(conds, v1beta.ConditionIdle)
+		setCondition(conds, peer.Generation, v1beta.ConditionIdle, false, "Reactivated", "")
+		meta.FindStatusCondition(*conds, v1beta.ConditionIdle).LastTransitionTime = metav1.NewTime(now)
+		return true
+	}
+
+	since := idleSince(peer)
+	if now.Sub(since) < threshold {
+		return setCondition(conds, peer.Generation, v1beta.ConditionIdle, false, "Active", "")
+	}
+
+	return setCondition(conds, peer.Generation, v1beta.ConditionIdle, true, "NoHandshake",
+		fmt.Sprintf("no handshake since %s", since.UTC().Format(time.RFC3339)))
+}
+
+// idleCandidate is a peer that exceeded the idle threshold.
+type idleCandidate struct {
+	Name     string
+	LastSeen time.Time
+	// Reported is set when the peer was already idle in an earlier round, only reported peers are acted upon.
+	Reported bool
+	// IdleSince is when the Idle condition turned true, the idle grace period counts from there.
+	IdleSince time.Time
+}
+
+func (c idleCandidate) String() string {
+	seen := "never"
+	if !c.LastSeen.IsZero() {
+		seen = c.LastSeen.UTC().Format(time.RFC3339)
+	}
+	return c.Name + " (last seen " + seen + ")"
+}
+
+// handleIdle reports idle peers and applies the idle action to the ones reported
+// at least the idle grace period ago.
+// Nothing is logged unless a peer became idle or is about to be acted upon.
+func (w *statusWriter) handleIdle(ctx context.Context, candidates []idleCandidate, now time.Time) {
+	if len(candidates) == 0 {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	act := []idleCandidate{}
+	report := false
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, c.String())
+		if !c.Reported {
+			report = true
+		} else if w.policy.IdleAction != IdleActionNone && now.Sub(c.IdleSince) >= w.policy.IdleGrace {
+			act = append(act, c)
+		}
+	}
+
+	if !report && len(act) == 0 {
+		return
+	}
+
+	w.log.Info("idle peer report",
+		"threshold", w.policy.IdleThreshold,
+		"action", w.policy.IdleAction,
+		"grace", w.policy.IdleGrace,
+		"candidates", names,
+		"acting", len(act),
+	)
+
+	changed := false
+	for _, c := range act {
+		peer := v1beta.WireguardAccessPeer{}
+		err := w.client.Get(ctx, client.ObjectKey{Name: c.Name}, &peer)
+		if err != nil {
+			w.log.Error("Error getting idle peer", "peer", c.Name, "error", err)
+			continue
+		}
+
+		// the peer may be stale, a conflict leaves it to the next round instead of overwriting a concurrent change
+		switch w.policy.IdleAction {
+		case IdleActionDisable:
+			patch := client.MergeFromWithOptions(peer.DeepCopy(), client.MergeFromWithOptimisticLock{})
+			peer.Spec.Disabled = true
+			err = w.client.Patch(ctx, &peer, patch)
+			if err != nil {
+				break
+			}
+
+			// otherwise re-enabling it would count as already reported
+			patch = client.MergeFromWithOptions(peer.DeepCopy(), client.MergeFromWithOptimisticLock{})
+			setIdleCondition(&peer, time.Now(), w.policy.IdleThreshold)
+			err = w.client.Status().Patch(ctx, &peer, patch)
+		case IdleActionDelete:
+			err = client.IgnoreNotFound(w.client.Delete(ctx, &peer))
+		}
+		if apierrors.IsConflict(err) {
+			w.log.Debug("idle peer changed, retrying next round", "peer", c.Name)
+			continue
+		}
+		if err != nil {
+			w.log.Error("Error acting on idle peer", "peer", c.Name, "action", w.policy.IdleAction, "error", err)
+			continue
+		}
+
+		w.log.Info("acted on idle peer", "peer", c.Name, "action", w.policy.IdleAction, "lastSeen", c.LastSeen)
+		changed = true
+	}
+
+	if changed {
+		err := w.syncer.Sync(ctx)
+		if err != nil {
+			w.log.Error("Error syncing after acting on idle peers", "error", err)
+		}
+	}
+}
+
+// wasIdle reports whether the peer's status already carries a true Idle condition.
+func wasIdle(peer *v1beta.WireguardAccessPeer) bool {
+	return peer.Status != nil && meta.IsStatusConditionTrue(peer.Status.Conditions, v1beta.ConditionIdle)
+}
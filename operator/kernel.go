@@ -0,0 +1,180 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// KernelDataplane applies changes to the host through netlink, wgctrl and nft.
+type KernelDataplane struct {
+	backend LinkBackend
+}
+
+func NewKernelDataplane(backend LinkBackend) *KernelDataplane {
+	return &KernelDataplane{
+		backend: backend,
+	}
+}
+
+func (d *KernelDataplane) AddLink(name string) error {
+	return d.backend.AddLink(name)
+}
+
+func (d *KernelDataplane) DelLink(name string) error {
+	return d.backend.DelLink(name)
+}
+
+func (d *KernelDataplane) Links(prefix string) ([]string, error) {
+	lnks, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, lnk := range lnks {
+		if lnk.Type() != d.backend.LinkType() {
+			continue
+		}
+
+		if !strings.HasPrefix(lnk.Attrs().Name, prefix) {
+			continue
+		}
+
+		names = append(names, lnk.Attrs().Name)
+	}
+
+	return names, nil
+}
+
+func (d *KernelDataplane) LinkUp(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("cannot get interface %s: %w", name, err)
+	}
+
+	return netlink.LinkSetUp(link)
+}
+
+func (d *KernelDataplane) Device(name string) (*wgtypes.Device, error) {
+	wg, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("wgctrl.New: %w", err)
+	}
+	defer wg.Close()
+
+	return wg.Device(name)
+}
+
+func (d *KernelDataplane) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	wg, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("wgctrl.New: %w", err)
+	}
+	defer wg.Close()
+
+	return wg.ConfigureDevice(name, cfg)
+}
+
+func (d *KernelDataplane) Addrs(name string) ([]net.IPNet, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get interface %s: %w", name, err)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	nets := make([]net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		nets = append(nets, *addr.IPNet)
+	}
+
+	return nets, nil
+}
+
+func (d *KernelDataplane) AddrReplace(name string, addr net.IPNet) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("cannot get interface %s: %w", name, err)
+	}
+
+	return netlink.AddrReplace(link, &netlink.Addr{IPNet: &addr})
+}
+
+func (d *KernelDataplane) AddrDel(name string, addr net.IPNet) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("cannot get interface %s: %w", name, err)
+	}
+
+	return netlink.AddrDel(link, &netlink.Addr{IPNet: &addr})
+}
+
+func (d *KernelDataplane) Routes(name string) ([]net.IPNet, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get interface %s: %w", name, err)
+	}
+
+	routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	dsts := make([]net.IPNet, 0, len(routes))
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+		dsts = append(dsts, *route.Dst)
+	}
+
+	return dsts, nil
+}
+
+func (d *KernelDataplane) RouteReplace(name string, dst net.IPNet) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("cannot get interface %s: %w", name, err)
+	}
+
+	return netlink.RouteReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &dst,
+	})
+}
+
+func (d *KernelDataplane) RouteDel(name string, dst net.IPNet) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("cannot get interface %s: %w", name, err)
+	}
+
+	return netlink.RouteDel(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &dst,
+	})
+}
+
+func (d *KernelDataplane) Sysctl(ctx context.Context, key string) (string, error) {
+	v, err := os.ReadFile("/proc/sys/" + strings.ReplaceAll(key, ".", "/"))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(v)), nil
+}
+
+func (d *KernelDataplane) SetSysctl(ctx context.Context, key, value string) error {
+	return exec.CommandContext(ctx, "sysctl", "-w", key+"="+value).Run()
+}
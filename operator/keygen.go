@@ -0,0 +1,125 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"github.com/kraudcloud/wga/pkgs/clientconfig"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SecretKeyConfig holds the rendered wg-quick config in a peer's private key secret.
+	SecretKeyConfig = "config"
+	// LabelPeer marks secrets generated for a peer.
+	LabelPeer = "wga.kraudcloud.com/peer"
+)
+
+// peerSecretRef returns the secret holding the generated private key of a peer, with defaults filled in.
+func peerSecretRef(peer *v1beta.WireguardAccessPeer) corev1.SecretReference {
+	ref := corev1.SecretReference{}
+	if peer.Spec.PrivateKeySecretRef != nil {
+		ref = *peer.Spec.PrivateKeySecretRef
+	}
+
+	if ref.Name == "" {
+		ref.Name = fmt.Sprintf("wgap-%s", peer.Name)
+	}
+
+	if ref.Namespace == "" {
+		ref.Namespace = getK8sNamespace()
+	}
+
+	return ref
+}
+
+// generateKey gives a peer without public key a keypair.
+// The private key is stored in a secret owned by the peer, an existing key is reused so retries don't change keys.
+func (r *PeerReconciler) generateKey(ctx context.Context, peer *v1beta.WireguardAccessPeer) error {
+	ref := peerSecretRef(peer)
+
+	privk, err := secretKey(ctx, r.client, ref, SecretKeyName)
+	if missingSecretKey(err) {
+		privk, err = wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return fmt.Errorf("error generating key: %w", err)
+		}
+
+		// the secret goes away with the peer
+		err = storeSecretKey(ctx, r.client, ref, SecretKeyName, []byte(privk.String()), map[string]string{LabelPeer: peer.Name}, nil, peer)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.log.Info("generated key for peer", "peer", peer.Name, "secret", ref.Namespace+"/"+ref.Name)
+
+	patch := client.MergeFrom(peer.DeepCopy())
+	peer.Spec.PublicKey = privk.PublicKey().String()
+	peer.Spec.PrivateKeySecretRef = &ref
+
+	err = r.client.Patch(ctx, peer, patch)
+	if err != nil {
+		return err
+	}
+
+	r.recorder.Eventf(peer, corev1.EventTypeNormal, "KeyGenerated", "generated keypair, private key stored in secret %s/%s", ref.Namespace, ref.Name)
+
+	return nil
+}
+
+// renderConfig keeps the wg-quick config next to a generated private key up to date,
+// so the peer can be handed out from the secret alone.
+func (r *PeerReconciler) renderConfig(ctx context.Context, peer *v1beta.WireguardAccessPeer) error {
+	if peer.Spec.PrivateKeySecretRef == nil || peer.Status == nil || len(peer.Status.Addresses) == 0 {
+		return nil
+	}
+
+	ref := peerSecretRef(peer)
+	pk, err := secretKey(ctx, r.client, ref, SecretKeyName)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := PeerConfig(ctx, r.client, peer, pk, getK8sNamespace())
+	if err != nil {
+		return err
+	}
+
+	config := &strings.Builder{}
+	err = clientconfig.Format(config, cfg)
+	if err != nil {
+		return fmt.Errorf("error rendering config: %w", err)
+	}
+
+	return storeSecretKey(ctx, r.client, ref, SecretKeyConfig, []byte(config.String()), nil, nil, nil)
+}
+
+// PeerConfig builds the client config of a peer from its current status, with the preshared keys read from their secrets.
+// Secret references without namespace point into namespace, the one the endpoint runs in.
+func PeerConfig(ctx context.Context, c client.Reader, peer *v1beta.WireguardAccessPeer, pk wgtypes.Key, namespace string) (clientconfig.ConfigFile, error) {
+	if peer.Status == nil {
+		return clientconfig.ConfigFile{}, fmt.Errorf("peer %s has no status yet", peer.Name)
+	}
+
+	if namespace == "" {
+		return clientconfig.ConfigFile{}, fmt.Errorf("no endpoint namespace to resolve the secret references of peer %s", peer.Name)
+	}
+
+	psk, err := peerPreSharedKey(ctx, c, peer, namespace)
+	if err != nil {
+		return clientconfig.ConfigFile{}, err
+	}
+
+	// the config carries the keys, never the references to them
+	peer, err = resolveStatusPreSharedKeys(ctx, c, peer, namespace)
+	if err != nil {
+		return clientconfig.ConfigFile{}, err
+	}
+
+	return clientconfig.FromPeer(*peer, pk, psk)
+}
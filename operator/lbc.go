@@ -12,6 +12,8 @@ import (
 	"github.com/apparentlymart/go-cidr/cidr"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -80,14 +82,16 @@ func hasNoIP(service *corev1.Service) bool {
 
 func registerLoadBalancerReconciler(mgr ctrl.Manager, serviceNets []net.IPNet, log *slog.Logger) {
 	err := ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		WithEventFilter(lbcPredicate).
+		For(&corev1.Service{}, builder.WithPredicates(lbcPredicate)).
 		Owns(&corev1.Service{}, builder.WithPredicates(lbcPredicate)).
 		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
 			return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(o)}}
 		}), builder.WithPredicates(lbcPredicate)).
+		// services that already have ips are never reconciled, the gauge follows all of them
+		Watches(&corev1.Service{}, countLoadBalancerIPs(mgr.GetClient(), log)).
 		Complete(reconcile.AsReconciler(mgr.GetClient(), &LoadBalancerClassReconciler{
 			client:      mgr.GetClient(),
+			recorder:    mgr.GetEventRecorderFor("wga-endpoint"),
 			serviceNets: serviceNets,
 			log:         log.With("component", "service-controller"),
 		}))
@@ -99,6 +103,7 @@ func registerLoadBalancerReconciler(mgr ctrl.Manager, serviceNets []net.IPNet, l
 
 type LoadBalancerClassReconciler struct {
 	client      client.Client
+	recorder    record.EventRecorder
 	serviceNets []net.IPNet
 	log         *slog.Logger
 }
@@ -132,12 +137,13 @@ func (r *LoadBalancerClassReconciler) Reconcile(ctx context.Context, svc *corev1
 	} else {
 		ips := strings.Split(svc.Annotations[LoadBalancerIPs], ",")
 		for _, ip := range ips {
-			ip := net.ParseIP(strings.TrimSpace(ip))
-			if ip == nil {
+			parsed := net.ParseIP(strings.TrimSpace(ip))
+			if parsed == nil {
+				r.recorder.Eventf(svc, corev1.EventTypeWarning, "InvalidLoadBalancerIP", "annotation %s contains invalid ip %q", LoadBalancerIPs, ip)
 				return reconcile.Result{}, reconcile.TerminalError(fmt.Errorf("invalid ip: %s", ip))
 			}
 
-			serviceIPs = append(serviceIPs, ip)
+			serviceIPs = append(serviceIPs, parsed)
 		}
 	}
 
@@ -158,6 +164,7 @@ func (r *LoadBalancerClassReconciler) Reconcile(ctx context.Context, svc *corev1
 				if ingress.IP == ip.String() {
 					// retry if the one we generated conflicts
 					if generated != nil && ip.String() == generated.String() {
+						r.recorder.Eventf(svc, corev1.EventTypeWarning, "IPConflict", "generated ip %s is already assigned to service %s/%s, retrying", ip, service.Namespace, service.Name)
 						return reconcile.Result{}, fmt.Errorf("generated ip %s is already assigned to another service", ip.String())
 					}
 
@@ -178,6 +185,8 @@ func (r *LoadBalancerClassReconciler) Reconcile(ctx context.Context, svc *corev1
 						return reconcile.Result{}, fmt.Errorf("unable to update service status: %w", err)
 					}
 
+					r.recorder.Eventf(svc, corev1.EventTypeWarning, "IPConflict", "requested ip %s is already assigned to service %s/%s", ip, service.Namespace, service.Name)
+
 					return reconcile.Result{}, nil
 				}
 			}
@@ -215,5 +224,64 @@ func (r *LoadBalancerClassReconciler) Reconcile(ctx context.Context, svc *corev1
 		return reconcile.Result{}, fmt.Errorf("unable to update service status: %w", err)
 	}
 
+	r.recorder.Eventf(svc, corev1.EventTypeNormal, "IPAssigned", "assigned %s", joinIPs(serviceIPs))
+
 	return reconcile.Result{}, nil
 }
+
+// countLoadBalancerIPs recounts the ips allocated to services of the wga LoadBalancerClass
+// whenever one of them is created, changed or deleted.
+func countLoadBalancerIPs(c client.Reader, log *slog.Logger) handler.Funcs {
+	recount := func(ctx context.Context, objs ...client.Object) {
+		relevant := false
+		for _, o := range objs {
+			if s, ok := o.(*corev1.Service); ok && isLoadBalancerClass(s) {
+				relevant = true
+			}
+		}
+		if !relevant {
+			return
+		}
+
+		services := &corev1.ServiceList{}
+		err := c.List(ctx, services)
+		if err != nil {
+			log.Error("unable to list services", "err", err)
+			return
+		}
+
+		n := 0
+		for _, s := range services.Items {
+			if !isLoadBalancerClass(&s) {
+				continue
+			}
+			for _, ingress := range s.Status.LoadBalancer.Ingress {
+				if ingress.IP != "" {
+					n++
+				}
+			}
+		}
+		loadBalancerIPs.Set(float64(n))
+	}
+
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, _ workqueue.RateLimitingInterface) {
+			recount(ctx, e.Object)
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, _ workqueue.RateLimitingInterface) {
+			recount(ctx, e.ObjectOld, e.ObjectNew)
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, _ workqueue.RateLimitingInterface) {
+			recount(ctx, e.Object)
+		},
+	}
+}
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+
+	return strings.Join(s, ", ")
+}
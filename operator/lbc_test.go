@@ -0,0 +1,50 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestLoadBalancerIPs(t *testing.T) {
+	ctx := context.Background()
+
+	service := func(name, class string, ips ...string) *corev1.Service {
+		s := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, LoadBalancerClass: &class},
+		}
+		for _, ip := range ips {
+			s.Status.LoadBalancer.Ingress = append(s.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+		}
+		return s
+	}
+
+	dual := service("dual", LoadBalancerClass, "10.3.0.1", "fd00:3::1")
+	single := service("single", LoadBalancerClass, "10.3.0.2")
+	other := service("other", "example.com/other", "192.0.2.1")
+	c := testClient(dual, single, other)
+	h := countLoadBalancerIPs(c, testLog)
+
+	h.Create(ctx, event.CreateEvent{Object: other}, nil)
+	if got := testutil.ToFloat64(loadBalancerIPs); got != 0 {
+		t.Errorf("services of other classes recounted, got %v", got)
+	}
+
+	h.Create(ctx, event.CreateEvent{Object: dual}, nil)
+	if got := testutil.ToFloat64(loadBalancerIPs); got != 3 {
+		t.Errorf("expected 3 ips, got %v", got)
+	}
+
+	if err := c.Delete(ctx, dual); err != nil {
+		t.Fatal(err)
+	}
+	h.Delete(ctx, event.DeleteEvent{Object: dual}, nil)
+	if got := testutil.ToFloat64(loadBalancerIPs); got != 1 {
+		t.Errorf("ips of deleted service still counted, got %v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PeerPolicy configures what the endpoint does with peers over their lifetime.
+type PeerPolicy struct {
+	// ExpiredGrace is how long an expired peer is kept before it is deleted.
+	// Zero keeps expired peers until someone deletes them.
+	ExpiredGrace time.Duration
+
+	// IdleThreshold is how long a peer may go without a handshake before it is idle.
+	// Zero disables idle detection.
+	IdleThreshold time.Duration
+	// IdleAction is applied to idle peers, one of IdleActionNone, IdleActionDisable or IdleActionDelete.
+	IdleAction string
+	// IdleGrace is how long a peer stays reported as idle before IdleAction is applied,
+	// counted from when its Idle condition turned true. It leaves time to review the reported peers.
+	IdleGrace time.Duration
+
+	// PSKRotationInterval is how often the preshared keys of peers are replaced, unless the peer sets its own.
+	// Zero never rotates.
+	PSKRotationInterval time.Duration
+}
+
+const (
+	InactiveDisabled = "Disabled"
+	InactiveExpired  = "Expired"
+)
+
+// peerInactive returns why a peer must not be on the dataplane, or "" if it may be.
+// Inactive peers keep their status, so they come back with the same address.
+func peerInactive(peer *v1beta.WireguardAccessPeer, now time.Time) string {
+	if peer.Spec.Disabled {
+		return InactiveDisabled
+	}
+
+	if peerExpired(peer, now) {
+		return InactiveExpired
+	}
+
+	return ""
+}
+
+func peerExpired(peer *v1beta.WireguardAccessPeer, now time.Time) bool {
+	return peer.Spec.ExpiresAt != nil && !now.Before(peer.Spec.ExpiresAt.Time)
+}
+
+// setExpiredCondition records whether the peer expired and reports whether that changed.
+func setExpiredCondition(peer *v1beta.WireguardAccessPeer, now time.Time) bool {
+	if peer.Spec.ExpiresAt == nil && meta.FindStatusCondition(peer.Status.Conditions, v1beta.ConditionExpired) == nil {
+		return false
+	}
+
+	if !peerExpired(peer, now) {
+		reason := "NotExpired"
+		if peer.Spec.ExpiresAt == nil {
+			reason = "NoExpiry"
+		}
+		return setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionExpired, false, reason, "")
+	}
+
+	return setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionExpired, true, "Expired",
+		fmt.Sprintf("peer expired at %s", peer.Spec.ExpiresAt.UTC().Format(time.RFC3339)))
+}
+
+// reconcileExpiry deletes the peer once the grace period after its expiry is over.
+// The peer is requeued for the next point in time something changes.
+func (r *PeerReconciler) reconcileExpiry(ctx context.Context, peer *v1beta.WireguardAccessPeer, now time.Time) (ctrl.Result, error) {
+	if peer.Spec.ExpiresAt == nil {
+		return ctrl.Result{}, nil
+	}
+
+	if !peerExpired(peer, now) {
+		return ctrl.Result{RequeueAfter: peer.Spec.ExpiresAt.Sub(now)}, nil
+	}
+
+	if r.policy.ExpiredGrace <= 0 {
+		return ctrl.Result{}, nil
+	}
+
+	deleteAt := peer.Spec.ExpiresAt.Add(r.policy.ExpiredGrace)
+	if now.Before(deleteAt) {
+		return ctrl.Result{RequeueAfter: deleteAt.Sub(now)}, nil
+	}
+
+	r.log.Info("deleting expired peer", "peer", peer.Name, "expiredAt", peer.Spec.ExpiresAt.Time)
+	return ctrl.Result{}, client.IgnoreNotFound(r.client.Delete(ctx, peer))
+}
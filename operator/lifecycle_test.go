@@ -0,0 +1,641 @@
+package operator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.org/x/time/rate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestPeerExpiry(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	alice := mustKey(t).PublicKey()
+	bob := mustKey(t).PublicKey()
+
+	expired := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+	expired.Spec.ExpiresAt = &metav1.Time{Time: now.Add(-time.Hour)}
+	valid := testPeer("bob", bob, []string{"intranet"}, "fd00:1::2")
+	valid.Spec.ExpiresAt = &metav1.Time{Time: now.Add(time.Hour)}
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&expired, &valid, &rule)
+
+	r := &PeerReconciler{
+		client:   c,
+		recorder: record.NewFakeRecorder(100),
+		dp:       dp,
+		syncer:   testSyncer(t, c, dp),
+		log:      testLog,
+		policy:   PeerPolicy{ExpiredGrace: 2 * time.Hour},
+	}
+
+	err := WGASync(c, dp, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if devicePeer(t, dp, alice) != nil {
+		t.Error("expired peer configured")
+	}
+	if devicePeer(t, dp, bob) == nil {
+		t.Error("valid peer not configured")
+	}
+
+	rules, _ := dp.FilterRules(ctx, DEVICENAME)
+	for _, rule := range rules {
+		if rule.Source.IP.String() == "fd00:1::1" {
+			t.Errorf("expired peer has rule %v", rule)
+		}
+	}
+
+	res, err := r.Reconcile(ctx, &valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequeueAfter <= 0 || res.RequeueAfter > time.Hour {
+		t.Errorf("expected requeue at expiry, got %v", res.RequeueAfter)
+	}
+
+	res, err = r.Reconcile(ctx, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequeueAfter <= 0 || res.RequeueAfter > time.Hour {
+		t.Errorf("expected requeue at end of grace period, got %v", res.RequeueAfter)
+	}
+
+	got := v1beta.WireguardAccessPeer{}
+	err = c.Get(ctx, client.ObjectKeyFromObject(&expired), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, v1beta.ConditionExpired) {
+		t.Errorf("expired condition not set: %v", got.Status.Conditions)
+	}
+
+	// past the grace period
+	_, err = r.reconcileExpiry(ctx, &got, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the finalizer keeps it until the endpoint confirmed it is off the device
+	err = c.Get(ctx, client.ObjectKeyFromObject(&expired), &got)
+	if err != nil || got.DeletionTimestamp.IsZero() {
+		t.Fatalf("expected expired peer to be terminating: %v", err)
+	}
+	_, err = r.Reconcile(ctx, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Get(ctx, client.ObjectKeyFromObject(&expired), &got)
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("expired peer not deleted: %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	expired := testPeer("alice", mustKey(t).PublicKey(), []string{"intranet"}, "fd00:1::1")
+	expired.Spec.ExpiresAt = &metav1.Time{Time: now.Add(-time.Hour)}
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&expired, &rule)
+	dry := newDryRunClient(c, testLog)
+
+	r := &PeerReconciler{
+		client:   dry,
+		recorder: record.NewFakeRecorder(100),
+		dp:       dp,
+		syncer:   testSyncer(t, dry, dp),
+		log:      testLog,
+		policy:   PeerPolicy{ExpiredGrace: time.Minute},
+	}
+
+	_, err := r.Reconcile(ctx, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.reconcileExpiry(ctx, &expired, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := v1beta.WireguardAccessPeer{}
+	err = c.Get(ctx, client.ObjectKeyFromObject(&expired), &got)
+	if err != nil {
+		t.Fatalf("dry run deleted the peer: %v", err)
+	}
+	if len(got.Finalizers) != 0 || !got.DeletionTimestamp.IsZero() {
+		t.Errorf("dry run wrote finalizers %v, deletion %v", got.Finalizers, got.DeletionTimestamp)
+	}
+	if len(got.Status.Conditions) != 0 {
+		t.Errorf("dry run wrote status conditions %v", got.Status.Conditions)
+	}
+}
+
+func TestPeerDisabled(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice := mustKey(t).PublicKey()
+	peer := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&peer, &rule)
+
+	r := &PeerReconciler{
+		client:   c,
+		recorder: record.NewFakeRecorder(100),
+		dp:       dp,
+		syncer:   testSyncer(t, c, dp),
+		log:      testLog,
+	}
+
+	err := WGASync(c, dp, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Reconcile(ctx, &peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer.Spec.Disabled = true
+	err = c.Update(ctx, &peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Reconcile(ctx, &peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if devicePeer(t, dp, alice) != nil {
+		t.Error("disabled peer still configured")
+	}
+	if rules, _ := dp.FilterRules(ctx, DEVICENAME); len(rules) != 0 {
+		t.Errorf("disabled peer has rules: %v", rules)
+	}
+
+	peer.Spec.Disabled = false
+	err = c.Update(ctx, &peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Reconcile(ctx, &peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := devicePeer(t, dp, alice)
+	if p == nil {
+		t.Fatal("re-enabled peer not configured")
+	}
+	if len(p.AllowedIPs) != 1 || p.AllowedIPs[0].IP.String() != "fd00:1::1" {
+		t.Errorf("re-enabled peer lost its address: %v", p.AllowedIPs)
+	}
+}
+
+func TestPeerDeletion(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice := mustKey(t).PublicKey()
+	peer := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&peer, &rule)
+	r := &PeerReconciler{client: c, recorder: record.NewFakeRecorder(100), dp: dp, syncer: testSyncer(t, c, dp), log: testLog}
+
+	if _, err := r.Reconcile(ctx, &peer); err != nil {
+		t.Fatal(err)
+	}
+	if devicePeer(t, dp, alice) == nil {
+		t.Fatal("peer not configured")
+	}
+
+	if err := c.Delete(ctx, &peer); err != nil {
+		t.Fatal(err)
+	}
+
+	got := v1beta.WireguardAccessPeer{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&peer), &got); err != nil {
+		t.Fatalf("peer deleted before it was removed from the device: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if devicePeer(t, dp, alice) != nil {
+		t.Error("deleted peer still configured")
+	}
+	if rules, _ := dp.FilterRules(ctx, DEVICENAME); len(rules) != 0 {
+		t.Errorf("deleted peer has rules: %v", rules)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&peer), &got); !apierrors.IsNotFound(err) {
+		t.Errorf("finalizer not released: %v", err)
+	}
+	if _, ok := r.synced["alice"]; ok {
+		t.Error("spec of deleted peer still recorded")
+	}
+}
+
+func TestIdlePeers(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice := mustKey(t).PublicKey()
+	bob := mustKey(t).PublicKey()
+
+	idle := testPeer("alice", alice, nil, "fd00:1::1")
+	idle.CreationTimestamp = metav1.NewTime(time.Now().Add(-48 * time.Hour))
+	active := testPeer("bob", bob, nil, "fd00:1::2")
+	active.CreationTimestamp = idle.CreationTimestamp
+
+	c := testClient(&idle, &active)
+
+	err := WGASync(c, dp, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp.SetPeerStats(DEVICENAME, wgtypes.Peer{PublicKey: bob, LastHandshakeTime: time.Now()})
+
+	w := &statusWriter{
+		client:  c,
+		syncer:  testSyncer(t, c, dp),
+		dp:      dp,
+		log:     testLog,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+		policy: PeerPolicy{
+			IdleThreshold: 24 * time.Hour,
+			IdleAction:    IdleActionDisable,
+		},
+	}
+
+	get := func(name string) v1beta.WireguardAccessPeer {
+		t.Helper()
+		p := v1beta.WireguardAccessPeer{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// first round only reports
+	if err := w.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	p := get("alice")
+	if !meta.IsStatusConditionTrue(p.Status.Conditions, v1beta.ConditionIdle) || p.Spec.Disabled {
+		t.Errorf("expected alice to be reported idle, got disabled=%v %v", p.Spec.Disabled, p.Status.Conditions)
+	}
+	p = get("bob")
+	if meta.IsStatusConditionTrue(p.Status.Conditions, v1beta.ConditionIdle) || p.Status.LastSeen == nil {
+		t.Errorf("expected bob to be seen and active, got %v", p.Status)
+	}
+
+	// second round acts
+	if err := w.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	p = get("alice")
+	if !p.Spec.Disabled {
+		t.Error("idle peer not disabled")
+	}
+	if devicePeer(t, dp, alice) != nil {
+		t.Error("idle peer still configured")
+	}
+
+	// re-enabling restarts the idle clock
+	p.Spec.Disabled = false
+	if err := c.Update(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p = get("alice")
+	if p.Spec.Disabled || meta.IsStatusConditionTrue(p.Status.Conditions, v1beta.ConditionIdle) {
+		t.Errorf("re-enabled peer idle again: %v", p.Status.Conditions)
+	}
+}
+
+// TestStatusConflict writes a condition while the status writer holds a cached copy of the peer,
+// the writer must not overwrite it with the conditions of its copy.
+func TestStatusConflict(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	peer := testPeer("alice", mustKey(t).PublicKey(), nil, "fd00:1::1")
+	peer.CreationTimestamp = metav1.NewTime(time.Now().Add(-48 * time.Hour))
+
+	concurrent := false
+	c := fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjects(&peer).
+		WithStatusSubresource(&v1beta.WireguardAccessPeer{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourcePatch: func(ctx context.Context, cl client.Client, sub string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+				if !concurrent {
+					concurrent = true
+					cur := v1beta.WireguardAccessPeer{}
+					if err := cl.Get(ctx, client.ObjectKeyFromObject(obj), &cur); err != nil {
+						return err
+					}
+					setCondition(&cur.Status.Conditions, cur.Generation, v1beta.ConditionReady, true, "Synced", "")
+					if err := cl.Status().Update(ctx, &cur); err != nil {
+						return err
+					}
+				}
+				return cl.SubResource(sub).Patch(ctx, obj, patch, opts...)
+			},
+		}).
+		Build()
+
+	if err := WGASync(c, dp, testLog); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &statusWriter{
+		client:  c,
+		syncer:  testSyncer(t, c, dp),
+		dp:      dp,
+		log:     testLog,
+		limiter: rate.NewLimiter(rate.Inf, 1),
+		policy:  PeerPolicy{IdleThreshold: 24 * time.Hour},
+	}
+
+	for range 2 {
+		if err := w.sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := v1beta.WireguardAccessPeer{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&peer), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, v1beta.ConditionReady) {
+		t.Errorf("concurrently written condition overwritten: %v", got.Status.Conditions)
+	}
+	cond := meta.FindStatusCondition(got.Status.Conditions, v1beta.ConditionIdle)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.ObservedGeneration != got.Generation {
+		t.Errorf("expected idle condition observing generation %d after retrying, got %v", got.Generation, cond)
+	}
+}
+
+func TestIdleGrace(t *testing.T) {
+	for _, action := range []string{IdleActionDisable, IdleActionDelete} {
+		t.Run(action, func(t *testing.T) {
+			dp := testEndpoint(t)
+			ctx := context.Background()
+
+			peer := testPeer("alice", mustKey(t).PublicKey(), nil, "fd00:1::1")
+			peer.CreationTimestamp = metav1.NewTime(time.Now().Add(-48 * time.Hour))
+			c := testClient(&peer)
+
+			w := &statusWriter{
+				client:  c,
+				syncer:  testSyncer(t, c, dp),
+				dp:      dp,
+				log:     testLog,
+				limiter: rate.NewLimiter(rate.Inf, 1),
+				policy: PeerPolicy{
+					IdleThreshold: 24 * time.Hour,
+					IdleAction:    action,
+					IdleGrace:     time.Hour,
+				},
+			}
+
+			// reported in the first round, then left alone for the grace period
+			for i := 0; i < 3; i++ {
+				if err := w.sync(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := v1beta.WireguardAccessPeer{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(&peer), &got); err != nil {
+				t.Fatalf("idle peer acted upon within the grace period: %v", err)
+			}
+			if got.Spec.Disabled {
+				t.Fatal("idle peer disabled within the grace period")
+			}
+			if !meta.IsStatusConditionTrue(got.Status.Conditions, v1beta.ConditionIdle) {
+				t.Fatalf("expected peer to be reported idle: %v", got.Status.Conditions)
+			}
+
+			// idle for longer than the grace period
+			patch := client.MergeFrom(got.DeepCopy())
+			meta.FindStatusCondition(got.Status.Conditions, v1beta.ConditionIdle).LastTransitionTime = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+			if err := c.Status().Patch(ctx, &got, patch); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.sync(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			err := c.Get(ctx, client.ObjectKeyFromObject(&peer), &got)
+			switch action {
+			case IdleActionDisable:
+				if err != nil || !got.Spec.Disabled {
+					t.Errorf("idle peer not disabled after the grace period: %v", err)
+				}
+			case IdleActionDelete:
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("idle peer not deleted after the grace period: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestPSKRotation(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "wga")
+	dp := testEndpoint(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	psk := mustKey(t)
+	ref := &corev1.SecretReference{Name: "wgap-alice", Namespace: "wga"}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: ref.Namespace},
+		Data:       map[string][]byte{SecretKeyPreSharedKey: []byte(psk.String())},
+	}
+
+	alice := mustKey(t).PublicKey()
+	due := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+	due.CreationTimestamp = metav1.Time{Time: now.Add(-48 * time.Hour)}
+	due.Spec.PreSharedKeySecretRef = ref
+	pinned := testPeer("bob", mustKey(t).PublicKey(), []string{"intranet"}, "fd00:1::2")
+	pinned.CreationTimestamp = due.CreationTimestamp
+	pinned.Spec.PreSharedKeySecretRef = &corev1.SecretReference{Name: "wgap-alice"}
+	pinned.Spec.PreSharedKeyRotationInterval = &metav1.Duration{}
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&due, &pinned, &rule, &secret)
+	r := &PeerReconciler{
+		client:   c,
+		recorder: record.NewFakeRecorder(100),
+		dp:       dp,
+		syncer:   testSyncer(t, c, dp),
+		log:      testLog,
+		policy:   PeerPolicy{PSKRotationInterval: 24 * time.Hour},
+	}
+
+	res, err := r.Reconcile(ctx, &due)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequeueAfter <= 23*time.Hour || res.RequeueAfter > 24*time.Hour {
+		t.Errorf("expected requeue at next rotation, got %v", res.RequeueAfter)
+	}
+
+	rotated, err := secretKey(ctx, c, *ref, SecretKeyPreSharedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == psk {
+		t.Fatal("preshared key not rotated")
+	}
+	if p := devicePeer(t, dp, alice); p == nil || p.PresharedKey != rotated {
+		t.Errorf("device not configured with rotated key: %v", p)
+	}
+
+	got := v1beta.WireguardAccessPeer{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&due), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.PreSharedKeyRotatedAt == nil {
+		t.Error("rotation not recorded in status")
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&secret), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Annotations[AnnotationPSKRotatedAt] == "" {
+		t.Error("rotation not recorded in secret")
+	}
+
+	// not due again, even if the status update of the rotation was lost
+	got.Status.PreSharedKeyRotatedAt = nil
+	if err := c.Status().Update(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := secretKey(ctx, c, *ref, SecretKeyPreSharedKey); again != rotated {
+		t.Error("preshared key rotated before it was due")
+	}
+
+	// an interval of 0 on the peer disables rotation
+	res, err = r.Reconcile(ctx, &pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequeueAfter != 0 {
+		t.Errorf("unexpected requeue for peer without rotation: %v", res.RequeueAfter)
+	}
+	if again, _ := secretKey(ctx, c, *ref, SecretKeyPreSharedKey); again != rotated {
+		t.Error("preshared key of peer with rotation disabled was rotated")
+	}
+}
+
+func TestPeerRevocation(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice := mustKey(t).PublicKey()
+	peer := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&peer, &rule)
+	r := &PeerReconciler{client: c, recorder: record.NewFakeRecorder(100), dp: dp, syncer: testSyncer(t, c, dp), log: testLog}
+
+	reconcile := func() *v1beta.WireguardAccessPeer {
+		t.Helper()
+		got := &v1beta.WireguardAccessPeer{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(&peer), got); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(&peer), got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	got := reconcile()
+	if devicePeer(t, dp, alice) == nil {
+		t.Fatal("peer not configured")
+	}
+	if meta.FindStatusCondition(got.Status.Conditions, v1beta.ConditionRevoked) != nil {
+		t.Error("peer that was never revoked has a Revoked condition")
+	}
+
+	rev := &v1beta.WireguardAccessRevocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "stolen"},
+		Spec:       v1beta.WireguardAccessRevocationSpec{PublicKey: alice.String(), Reason: "laptop stolen"},
+	}
+	if err := c.Create(ctx, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	got = reconcile()
+	if devicePeer(t, dp, alice) != nil {
+		t.Error("revoked peer still configured")
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, v1beta.ConditionRevoked) {
+		t.Errorf("expected Revoked condition: %v", got.Status.Conditions)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, v1beta.ConditionReady); cond == nil || cond.Reason != "Revoked" {
+		t.Errorf("expected peer not ready because revoked: %v", cond)
+	}
+
+	// a full sync doesn't bring it back
+	if err := WGASync(c, dp, testLog); err != nil {
+		t.Fatal(err)
+	}
+	if devicePeer(t, dp, alice) != nil {
+		t.Error("revoked peer configured by sync")
+	}
+
+	if err := c.Delete(ctx, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	got = reconcile()
+	if devicePeer(t, dp, alice) == nil {
+		t.Error("peer not configured after revocation was deleted")
+	}
+	if meta.IsStatusConditionTrue(got.Status.Conditions, v1beta.ConditionRevoked) {
+		t.Error("peer still flagged as revoked")
+	}
+}
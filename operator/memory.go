@@ -0,0 +1,457 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// MemoryDataplane keeps the dataplane in memory and records every change.
+// It backs the test suite and --dry-run, where the changes are logged instead of applied.
+type MemoryDataplane struct {
+	lock sync.Mutex
+	log  *slog.Logger
+
+	links      map[string]*memoryLink
+	chains     map[string][]FilterRule
+	masquerade []string
+	sysctls    map[string]string
+	nextHandle uint64
+
+	changes []string
+}
+
+type memoryLink struct {
+	up     bool
+	device wgtypes.Device
+	addrs  []net.IPNet
+	routes []net.IPNet
+}
+
+// NewMemoryDataplane returns an empty dataplane. If log is not nil, every change is logged to it.
+func NewMemoryDataplane(log *slog.Logger) *MemoryDataplane {
+	return &MemoryDataplane{
+		log:     log,
+		links:   map[string]*memoryLink{},
+		chains:  map[string][]FilterRule{},
+		sysctls: map[string]string{},
+	}
+}
+
+func (d *MemoryDataplane) record(format string, args ...any) {
+	change := fmt.Sprintf(format, args...)
+	d.changes = append(d.changes, change)
+	if d.log != nil {
+		d.log.Info("dataplane change", "change", change)
+	}
+}
+
+// Changes returns the changes applied so far, in order.
+func (d *MemoryDataplane) Changes() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return slices.Clone(d.changes)
+}
+
+// Reset forgets the recorded changes but keeps the state.
+func (d *MemoryDataplane) Reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.changes = nil
+}
+
+func (d *MemoryDataplane) link(name string) (*memoryLink, error) {
+	l, ok := d.links[name]
+	if !ok {
+		return nil, fmt.Errorf("link %s not found", name)
+	}
+	return l, nil
+}
+
+func (d *MemoryDataplane) AddLink(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.links[name]; ok {
+		return fmt.Errorf("link %s already exists", name)
+	}
+
+	d.links[name] = &memoryLink{
+		device: wgtypes.Device{Name: name},
+	}
+	d.record("link add %s", name)
+	return nil
+}
+
+func (d *MemoryDataplane) DelLink(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.links[name]; !ok {
+		return nil
+	}
+
+	delete(d.links, name)
+	delete(d.chains, name)
+	d.record("link del %s", name)
+	return nil
+}
+
+func (d *MemoryDataplane) Links(prefix string) ([]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	names := []string{}
+	for name := range d.links {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (d *MemoryDataplane) LinkUp(name string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return err
+	}
+
+	if !l.up {
+		l.up = true
+		d.record("link up %s", name)
+	}
+	return nil
+}
+
+// IsUp reports whether the link exists and is up.
+func (d *MemoryDataplane) IsUp(name string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, ok := d.links[name]
+	return ok && l.up
+}
+
+func (d *MemoryDataplane) Device(name string) (*wgtypes.Device, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return nil, err
+	}
+
+	dev := l.device
+	dev.Peers = make([]wgtypes.Peer, len(l.device.Peers))
+	for i, p := range l.device.Peers {
+		p.AllowedIPs = slices.Clone(p.AllowedIPs)
+		dev.Peers[i] = p
+	}
+	return &dev, nil
+}
+
+func (d *MemoryDataplane) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return err
+	}
+
+	dev := &l.device
+	if cfg.PrivateKey != nil {
+		dev.PrivateKey = *cfg.PrivateKey
+		dev.PublicKey = cfg.PrivateKey.PublicKey()
+		d.record("wg %s private-key %s", name, dev.PublicKey)
+	}
+
+	if cfg.ListenPort != nil {
+		dev.ListenPort = *cfg.ListenPort
+		d.record("wg %s listen-port %d", name, dev.ListenPort)
+	}
+
+	if cfg.ReplacePeers && len(dev.Peers) > 0 {
+		dev.Peers = nil
+		d.record("wg %s replace-peers", name)
+	}
+
+	for _, pc := range cfg.Peers {
+		i := slices.IndexFunc(dev.Peers, func(p wgtypes.Peer) bool {
+			return p.PublicKey == pc.PublicKey
+		})
+
+		if pc.Remove {
+			if i >= 0 {
+				dev.Peers = slices.Delete(dev.Peers, i, i+1)
+				d.record("wg %s peer %s remove", name, pc.PublicKey)
+			}
+			continue
+		}
+
+		if i < 0 {
+			if pc.UpdateOnly {
+				continue
+			}
+			dev.Peers = append(dev.Peers, wgtypes.Peer{PublicKey: pc.PublicKey})
+			i = len(dev.Peers) - 1
+			d.record("wg %s peer %s add", name, pc.PublicKey)
+		}
+
+		p := &dev.Peers[i]
+		if pc.PresharedKey != nil {
+			p.PresharedKey = *pc.PresharedKey
+		}
+		if pc.Endpoint != nil {
+			p.Endpoint = pc.Endpoint
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			p.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			p.AllowedIPs = nil
+		}
+		p.AllowedIPs = append(p.AllowedIPs, pc.AllowedIPs...)
+
+		d.record("wg %s peer %s set allowed-ips %s keepalive %s endpoint %v", name, pc.PublicKey, joinNets(p.AllowedIPs), p.PersistentKeepaliveInterval, p.Endpoint)
+	}
+
+	return nil
+}
+
+// SetPeerStats sets the session state of a peer, as if it had been connected.
+func (d *MemoryDataplane) SetPeerStats(name string, stats wgtypes.Peer) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range l.device.Peers {
+		if p.PublicKey == stats.PublicKey {
+			l.device.Peers[i].LastHandshakeTime = stats.LastHandshakeTime
+			l.device.Peers[i].ReceiveBytes = stats.ReceiveBytes
+			l.device.Peers[i].TransmitBytes = stats.TransmitBytes
+			if stats.Endpoint != nil {
+				l.device.Peers[i].Endpoint = stats.Endpoint
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("peer %s not found on %s", stats.PublicKey, name)
+}
+
+func (d *MemoryDataplane) Addrs(name string) ([]net.IPNet, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(l.addrs), nil
+}
+
+func (d *MemoryDataplane) AddrReplace(name string, addr net.IPNet) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(l.addrs, func(a net.IPNet) bool { return ipNetsEqual(a, addr) }) {
+		return nil
+	}
+
+	l.addrs = append(l.addrs, addr)
+	d.record("addr add %s dev %s", addr.String(), name)
+	return nil
+}
+
+func (d *MemoryDataplane) AddrDel(name string, addr net.IPNet) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(l.addrs, func(a net.IPNet) bool { return ipNetsEqual(a, addr) })
+	if i < 0 {
+		return fmt.Errorf("addr %s not found on %s", addr.String(), name)
+	}
+
+	l.addrs = slices.Delete(l.addrs, i, i+1)
+	d.record("addr del %s dev %s", addr.String(), name)
+	return nil
+}
+
+func (d *MemoryDataplane) Routes(name string) ([]net.IPNet, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(l.routes), nil
+}
+
+func (d *MemoryDataplane) RouteReplace(name string, dst net.IPNet) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return err
+	}
+
+	if slices.ContainsFunc(l.routes, func(r net.IPNet) bool { return ipNetsEqual(r, dst) }) {
+		return nil
+	}
+
+	l.routes = append(l.routes, dst)
+	d.record("route add %s dev %s", dst.String(), name)
+	return nil
+}
+
+func (d *MemoryDataplane) RouteDel(name string, dst net.IPNet) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	l, err := d.link(name)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(l.routes, func(r net.IPNet) bool { return ipNetsEqual(r, dst) })
+	if i < 0 {
+		return fmt.Errorf("route %s not found on %s", dst.String(), name)
+	}
+
+	l.routes = slices.Delete(l.routes, i, i+1)
+	d.record("route del %s dev %s", dst.String(), name)
+	return nil
+}
+
+func (d *MemoryDataplane) EnsureMasquerade(ctx context.Context, oifname string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if slices.Contains(d.masquerade, oifname) {
+		return nil
+	}
+
+	d.masquerade = append(d.masquerade, oifname)
+	d.record("nft masquerade oifname %s", oifname)
+	return nil
+}
+
+func (d *MemoryDataplane) EnsureFilterChain(ctx context.Context, device string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.chains[device]; ok {
+		return nil
+	}
+
+	d.chains[device] = []FilterRule{}
+	d.record("nft add chain %s", device)
+	return nil
+}
+
+func (d *MemoryDataplane) FilterRules(ctx context.Context, device string) ([]FilterRule, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	rules, ok := d.chains[device]
+	if !ok {
+		return nil, ErrNoChain
+	}
+	return slices.Clone(rules), nil
+}
+
+func (d *MemoryDataplane) AddFilterRule(ctx context.Context, device string, rule FilterRule) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	rules, ok := d.chains[device]
+	if !ok {
+		return ErrNoChain
+	}
+
+	d.nextHandle++
+	rule.Handle = d.nextHandle
+	d.chains[device] = append(rules, rule)
+	d.record("nft add rule %s %s", device, rule)
+	return nil
+}
+
+func (d *MemoryDataplane) DelFilterRule(ctx context.Context, device string, rule FilterRule) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	rules, ok := d.chains[device]
+	if !ok {
+		return ErrNoChain
+	}
+
+	i := slices.IndexFunc(rules, func(r FilterRule) bool { return r.Handle == rule.Handle })
+	if i < 0 {
+		return fmt.Errorf("rule %d not found in %s", rule.Handle, device)
+	}
+
+	d.record("nft delete rule %s %s", device, rules[i])
+	d.chains[device] = slices.Delete(rules, i, i+1)
+	return nil
+}
+
+func (d *MemoryDataplane) Sysctl(ctx context.Context, key string) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	v, ok := d.sysctls[key]
+	if !ok {
+		return "", fmt.Errorf("sysctl %s not set", key)
+	}
+	return v, nil
+}
+
+func (d *MemoryDataplane) SetSysctl(ctx context.Context, key, value string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.sysctls[key] == value {
+		return nil
+	}
+
+	d.sysctls[key] = value
+	d.record("sysctl %s=%s", key, value)
+	return nil
+}
+
+func joinNets(nets []net.IPNet) string {
+	strs := make([]string, 0, len(nets))
+	for _, n := range nets {
+		strs = append(strs, n.String())
+	}
+	return strings.Join(strs, ",")
+}
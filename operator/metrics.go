@@ -0,0 +1,219 @@
+package operator
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+const (
+	// DefaultMetricsAddress is where the manager serves /metrics unless WGA_METRICS_ADDRESS is set.
+	DefaultMetricsAddress = ":8080"
+
+	PeerStateActive  = "active"
+	PeerStateIdle    = "idle"
+	PeerStateNever   = "never"
+	PeerStatePending = "pending"
+
+	// peerActiveWindow is how recent a handshake must be for a peer to count as active.
+	// wireguard rekeys every 2 minutes on an active session.
+	peerActiveWindow = 3 * time.Minute
+)
+
+var (
+	syncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "wga",
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of a full WGASync.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	syncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wga",
+		Name:      "sync_errors_total",
+		Help:      "Number of WGASync failures by stage and whether retrying can fix them.",
+	}, []string{"stage", "kind"})
+
+	syncLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "sync_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful sync, 0 if there was none yet.",
+	})
+
+	syncLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "wga",
+		Name:      "sync_latency_seconds",
+		Help:      "Time from the first sync request of a batch until the sync serving it finished.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	syncGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "sync_generation",
+		Help:      "Generation of the last sync request served by the sync worker.",
+	})
+
+	syncRequestedGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "sync_requested_generation",
+		Help:      "Generation of the last sync request, ahead of wga_sync_generation while a sync is pending.",
+	})
+
+	nftRules = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "nft_rules",
+		Help:      "Number of nft rules in the wga chain after the last sync.",
+	})
+
+	peersByState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "peers",
+		Help:      "Number of WireguardAccessPeers by connection state.",
+	}, []string{"state"})
+
+	peerHandshakeAge = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "wga",
+		Name:      "peer_handshake_age_seconds",
+		Help:      "Age of the last handshake of each connected peer, observed on every status poll.",
+		Buckets:   []float64{30, 60, 120, 180, 300, 900, 3600, 4 * 3600, 24 * 3600, 7 * 24 * 3600},
+	})
+
+	peerReceiveBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "peer_receive_bytes",
+		Help:      "Bytes received from the peer since the wg device was created.",
+	}, []string{"peer"})
+
+	peerTransmitBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "peer_transmit_bytes",
+		Help:      "Bytes sent to the peer since the wg device was created.",
+	}, []string{"peer"})
+
+	loadBalancerIPs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "wga",
+		Name:      "loadbalancer_ips",
+		Help:      "Number of ips allocated to services of the wga LoadBalancerClass.",
+	})
+
+	wgcHandshakeAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "wgc",
+		Name:      "handshake_age_seconds",
+		Help:      "Age of the last handshake with the server per wgc interface, -1 if there never was one.",
+	}, []string{"interface"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		syncDuration,
+		syncErrors,
+		syncLastSuccess,
+		syncLatency,
+		syncGeneration,
+		syncRequestedGeneration,
+		nftRules,
+		peersByState,
+		peerHandshakeAge,
+		peerReceiveBytes,
+		peerTransmitBytes,
+		loadBalancerIPs,
+		wgcHandshakeAge,
+	)
+}
+
+// managerOptions serves the controller-runtime metrics registry on WGA_METRICS_ADDRESS
+// and the health probes on WGA_HEALTH_ADDRESS.
+// With dryRun, the manager's client only dry runs and logs writes, see newDryRunClient.
+func managerOptions(dryRun bool) manager.Options {
+	addr := os.Getenv("WGA_METRICS_ADDRESS")
+	if addr == "" {
+		addr = DefaultMetricsAddress
+	}
+
+	healthAddr := os.Getenv("WGA_HEALTH_ADDRESS")
+	if healthAddr == "" {
+		healthAddr = DefaultHealthAddress
+	}
+
+	opts := manager.Options{
+		Metrics: metricsserver.Options{
+			BindAddress: addr,
+		},
+		HealthProbeBindAddress: healthAddr,
+		// secrets are read from the api server, only the ones generated for peers are watched and cached
+		Client: client.Options{
+			Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}},
+		},
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Secret{}: {Label: peerSecretSelector()},
+			},
+		},
+	}
+
+	if webhookEnabled() {
+		opts.WebhookServer = webhookServer()
+	}
+
+	if dryRun {
+		log := slog.Default().With("component", "dry-run")
+		opts.NewClient = func(config *rest.Config, o client.Options) (client.Client, error) {
+			c, err := client.New(config, o)
+			if err != nil {
+				return nil, err
+			}
+			return newDryRunClient(c, log), nil
+		}
+	}
+
+	return opts
+}
+
+// handshakeState classifies a handshake time relative to now.
+func handshakeState(lastHandshake time.Time, now time.Time) string {
+	if lastHandshake.IsZero() {
+		return PeerStateNever
+	}
+
+	if now.Sub(lastHandshake) <= peerActiveWindow {
+		return PeerStateActive
+	}
+
+	return PeerStateIdle
+}
+
+// PeerState classifies a peer by the handshake recorded in its status, for clients that can't see the device.
+// The status lags the device by up to StatusMinWriteInterval, so handshakes count as active for that much longer.
+func PeerState(peer *v1beta.WireguardAccessPeer, now time.Time) string {
+	if peer.Status == nil {
+		return PeerStatePending
+	}
+
+	last := time.Time{}
+	if c := peer.Status.Connection; c != nil && c.LastHandshake != nil {
+		last = c.LastHandshake.Time
+	}
+	if seen := peer.Status.LastSeen; seen != nil && seen.After(last) {
+		last = seen.Time
+	}
+
+	if last.IsZero() {
+		return PeerStateNever
+	}
+
+	if now.Sub(last) <= peerActiveWindow+StatusMinWriteInterval {
+		return PeerStateActive
+	}
+
+	return PeerStateIdle
+}
@@ -10,39 +10,28 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/nftables"
 )
 
-func sysctl(ctx context.Context, log *slog.Logger) {
-	cmd := exec.CommandContext(ctx, "sysctl", "-w", "net.ipv6.conf.all.forwarding=1")
-	if err := cmd.Run(); err != nil {
+func sysctl(ctx context.Context, log *slog.Logger, dp Dataplane) {
+	if err := dp.SetSysctl(ctx, "net.ipv6.conf.all.forwarding", "1"); err != nil {
 		log.Error("failed to run sysctl", "error", err)
 	}
 }
 
 var NFTInitOnce = sync.Once{}
 
-func nftInit() {
-	cmd := exec.Command("nft", "add", "table", "inet", "filter")
-	if err := cmd.Run(); err != nil {
-		slog.Error("failed to add table inet filter", "error", err)
-	}
-
-	cmd = exec.Command("nft", "add", "chain", "inet", "filter", "postrouting", "{ type nat hook postrouting priority 100 ; }")
-	if err := cmd.Run(); err != nil {
-		slog.Error("failed to add chain inet filter postrouting", "error", err)
-	}
-
-	cmd = exec.Command("nft", "add", "rule", "inet", "filter", "postrouting", "oifname", "eth0", "masquerade")
-	if err := cmd.Run(); err != nil {
-		slog.Error("failed to add rule inet filter postrouting oifname eth0 masquerade", "error", err)
+func nftInit(dp Dataplane) {
+	if err := dp.EnsureMasquerade(context.Background(), "eth0"); err != nil {
+		slog.Error("failed to set up masquerade", "error", err)
 	}
 }
 
 //TODO: this doesnt scale and should be replaced with a map
 
-func nftSync(ctx context.Context, log *slog.Logger, config *Config, deviceName string) {
+func nftSync(ctx context.Context, log *slog.Logger, dp Dataplane, config *Config, deviceName string) error {
 	ruleNameToDestinations := make(map[string][]net.IPNet)
 	for _, rr := range config.Rules {
 
@@ -60,44 +49,37 @@ func nftSync(ctx context.Context, log *slog.Logger, config *Config, deviceName s
 
 	log.Debug("ruleNameToDestinations created")
 
-	nft, err := nftables.New()
-	if err != nil {
-		panic(err)
-	}
-	defer nft.Flush()
-
-	table, err := checkOrCreateTable(nft)
-	if err != nil {
-		panic(err)
-	}
-
-	log.Debug("table checked or created")
-
-	chain, err := checkOrCreateWGAIngressChain(ctx, nft, table, deviceName)
+	err := dp.EnsureFilterChain(ctx, deviceName)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("error ensuring filter chain: %w", err)
 	}
 
 	log.Debug("chain checked or created")
 
-	rules, err := nft.GetRules(table, chain)
+	rules, err := dp.FilterRules(ctx, deviceName)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("error listing filter rules: %w", err)
 	}
 
-	ruleMap := make(map[string]*nftables.Rule)
+	ruleMap := make(map[string][]FilterRule)
 	for _, r := range rules {
-		ruleMap[string(r.UserData)] = r
+		ruleMap[r.Comment] = append(ruleMap[r.Comment], r)
 	}
 
 	log.Debug("ruleMap created")
 
+	now := time.Now()
 	for _, peer := range config.Peers {
 		if peer.Status == nil {
 			// will be reconciled later
 			continue
 		}
 
+		if _, revoked := config.Revoked[peer.Spec.PublicKey]; revoked || peerInactive(&peer, now) != "" {
+			// its rules are removed with the stale ones below
+			continue
+		}
+
 		if len(peer.Status.Addresses) == 0 {
 			peer.Status.Addresses = []string{peer.Status.Address}
 		}
@@ -125,14 +107,8 @@ func nftSync(ctx context.Context, log *slog.Logger, config *Config, deviceName s
 
 					comment := "r" + strip(snet.String()+dnet.String())
 
-					exists := false
-					for ud := range ruleMap {
-						if strings.Contains(ud, comment) {
-							delete(ruleMap, ud)
-							exists = true
-						}
-					}
-					if exists {
+					if _, exists := ruleMap[comment]; exists {
+						delete(ruleMap, comment)
 						continue
 					}
 
@@ -141,7 +117,11 @@ func nftSync(ctx context.Context, log *slog.Logger, config *Config, deviceName s
 						continue
 					}
 
-					err = routingRule(ctx, table, chain, snetIsV6, snet, dnet, comment)
+					err = dp.AddFilterRule(ctx, deviceName, FilterRule{
+						Comment:     comment,
+						Source:      snet,
+						Destination: dnet.String(),
+					})
 					if err != nil {
 						log.ErrorContext(ctx, "failed to add routing rule", "peer", peer.Name, "err", err)
 						continue
@@ -154,24 +134,32 @@ func nftSync(ctx context.Context, log *slog.Logger, config *Config, deviceName s
 			if snetIsV6 && len(peer.Status.DNS) > 0 {
 
 				comment := "r" + strip(snet.String()+peer.Status.DNS[0])
-				exists := false
-				for ud := range ruleMap {
-					if strings.Contains(ud, comment) {
-						delete(ruleMap, ud)
-						exists = true
-					}
+				if _, exists := ruleMap[comment]; exists {
+					delete(ruleMap, comment)
+					continue
 				}
-				if !exists {
-					err = dnsRule(ctx, table, chain, peer.Status.DNS[0], snet, comment)
-					if err != nil {
-						log.ErrorContext(ctx, "failed to add dns rule", "peer", peer.Name, "err", err)
-						continue
-					}
-					err = httpRule(ctx, table, chain, peer.Status.DNS[0], snet, comment)
-					if err != nil {
-						log.ErrorContext(ctx, "failed to add http rule", "peer", peer.Name, "err", err)
-						continue
-					}
+
+				err = dp.AddFilterRule(ctx, deviceName, FilterRule{
+					Comment:     comment,
+					Source:      snet,
+					Destination: peer.Status.DNS[0],
+					Protocol:    "udp",
+					Ports:       "53",
+				})
+				if err != nil {
+					log.ErrorContext(ctx, "failed to add dns rule", "peer", peer.Name, "err", err)
+					continue
+				}
+				err = dp.AddFilterRule(ctx, deviceName, FilterRule{
+					Comment:     comment,
+					Source:      snet,
+					Destination: peer.Status.DNS[0],
+					Protocol:    "tcp",
+					Ports:       "{ 80, 443 }",
+				})
+				if err != nil {
+					log.ErrorContext(ctx, "failed to add http rule", "peer", peer.Name, "err", err)
+					continue
 				}
 			}
 		}
@@ -180,13 +168,23 @@ func nftSync(ctx context.Context, log *slog.Logger, config *Config, deviceName s
 	log.Debug("rules added")
 
 	for _, stale := range ruleMap {
-		err := nft.DelRule(stale)
-		if err != nil {
-			log.WarnContext(ctx, "error deleting stale rule", "err", err, "rule", stale)
+		for _, r := range stale {
+			err := dp.DelFilterRule(ctx, deviceName, r)
+			if err != nil {
+				log.WarnContext(ctx, "error deleting stale rule", "err", err, "rule", r)
+			}
 		}
 	}
 
 	log.Debug("stale rules deleted")
+
+	rules, err = dp.FilterRules(ctx, deviceName)
+	if err != nil {
+		return fmt.Errorf("error listing filter rules: %w", err)
+	}
+	nftRules.Set(float64(len(rules)))
+
+	return nil
 }
 
 func strip(s string) string {
@@ -202,70 +200,95 @@ func strip(s string) string {
 	return result.String()
 }
 
-func checkOrCreateTable(nft *nftables.Conn) (*nftables.Table, error) {
-	tables, err := nft.ListTables()
-	if err != nil {
-		return nil, err
+// nftTable is the netdev table holding the ingress chains of the wga devices.
+const nftTable = "wga"
+
+func (d *KernelDataplane) EnsureMasquerade(ctx context.Context, oifname string) error {
+	errs := []error{}
+
+	cmd := exec.CommandContext(ctx, "nft", "add", "table", "inet", "filter")
+	if err := cmd.Run(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to add table inet filter: %w", err))
 	}
 
-	for _, t := range tables {
-		if t.Name == "wga" {
-			return t, nil
-		}
+	cmd = exec.CommandContext(ctx, "nft", "add", "chain", "inet", "filter", "postrouting", "{ type nat hook postrouting priority 100 ; }")
+	if err := cmd.Run(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to add chain inet filter postrouting: %w", err))
 	}
 
-	return nft.AddTable(&nftables.Table{
-		Family: nftables.TableFamilyNetdev,
-		Name:   "wga",
-	}), nft.Flush()
+	cmd = exec.CommandContext(ctx, "nft", "add", "rule", "inet", "filter", "postrouting", "oifname", oifname, "masquerade")
+	if err := cmd.Run(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to add rule inet filter postrouting oifname %s masquerade: %w", oifname, err))
+	}
+
+	return errors.Join(errs...)
 }
 
-func checkOrCreateWGAIngressChain(ctx context.Context, nft *nftables.Conn, table *nftables.Table, deviceName string) (
-	*nftables.Chain, error,
-) {
-	chains, err := nft.ListChains()
+func (d *KernelDataplane) EnsureFilterChain(ctx context.Context, device string) error {
+	nft, err := nftables.New()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	for _, c := range chains {
-		if c.Name == deviceName && c.Table.Name == table.Name {
-			return c, nil
-		}
+	table, err := checkOrCreateTable(nft)
+	if err != nil {
+		return err
 	}
 
-	cmd := exec.CommandContext(ctx, "nft", "add", "chain", "netdev", deviceName, deviceName, "{ type filter hook ingress device "+deviceName+" priority 0 ; policy drop; }")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	_, err = checkOrCreateWGAIngressChain(ctx, nft, table, device)
+	return err
+}
+
+func (d *KernelDataplane) FilterRules(ctx context.Context, device string) ([]FilterRule, error) {
+	nft, err := nftables.New()
 	if err != nil {
 		return nil, err
 	}
 
-	chains, err = nft.ListChains()
+	table, chain, err := findChain(nft, device)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, c := range chains {
-		if c.Name == deviceName && c.Table.Name == table.Name {
-			return c, nil
-		}
+	rules, err := nft.GetRules(table, chain)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, nil
+	frs := make([]FilterRule, 0, len(rules))
+	for _, r := range rules {
+		frs = append(frs, FilterRule{
+			Comment: ruleComment(r.UserData),
+			Handle:  r.Handle,
+		})
+	}
+
+	return frs, nil
 }
 
-func dnsRule(ctx context.Context, table *nftables.Table, chain *nftables.Chain, DNS string, snet net.IPNet, comment string) error {
-	err := exec.CommandContext(
-		ctx, "nft", "add", "rule", "netdev", table.Name, chain.Name,
-		"ip6", "saddr", snet.String(),
-		"ip6", "daddr", DNS,
-		"udp", "dport", "53",
+func (d *KernelDataplane) AddFilterRule(ctx context.Context, device string, rule FilterRule) error {
+	ipp := rule.family()
+
+	args := []string{"add", "rule", "netdev", nftTable, device,
+		ipp, "saddr", rule.Source.String(),
+		ipp, "daddr", rule.Destination,
+	}
+	if rule.Protocol != "" {
+		args = append(args, rule.Protocol, "dport", rule.Ports)
+	}
+	args = append(args,
 		"counter",
 		"accept",
-		"comment", comment,
-	).Run()
+		"comment", rule.Comment,
+	)
+
+	slog.Info("adding rule", "nft", args)
+
+	cmd := exec.CommandContext(ctx, "nft", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
 	if err != nil {
 		exitError := &exec.ExitError{}
 		if errors.As(err, &exitError) {
@@ -278,58 +301,103 @@ func dnsRule(ctx context.Context, table *nftables.Table, chain *nftables.Chain,
 	return nil
 }
 
-func httpRule(ctx context.Context, table *nftables.Table, chain *nftables.Chain, DNS string, snet net.IPNet, comment string) error {
-	err := exec.CommandContext(
-		ctx, "nft", "add", "rule", "netdev", table.Name, chain.Name,
-		"ip6", "saddr", snet.String(),
-		"ip6", "daddr", DNS,
-		"tcp", "dport", "{ 80, 443 }",
-		"counter",
-		"accept",
-		"comment", comment,
-	).Run()
+func (d *KernelDataplane) DelFilterRule(ctx context.Context, device string, rule FilterRule) error {
+	nft, err := nftables.New()
 	if err != nil {
-		exitError := &exec.ExitError{}
-		if errors.As(err, &exitError) {
-			return fmt.Errorf("nftables error: %s", string(exitError.Stderr))
-		}
+		return err
+	}
 
+	table, chain, err := findChain(nft, device)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	err = nft.DelRule(&nftables.Rule{
+		Table:  table,
+		Chain:  chain,
+		Handle: rule.Handle,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nft.Flush()
 }
 
-func routingRule(ctx context.Context, table *nftables.Table, chain *nftables.Chain, isV6 bool, snet net.IPNet, dnet net.IPNet, comment string) error {
+// ruleComment extracts the comment the nft cli stores as a TLV in the rule's userdata.
+func ruleComment(ud []byte) string {
+	if len(ud) >= 2 && ud[0] == 0 && int(ud[1]) <= len(ud)-2 {
+		return strings.TrimRight(string(ud[2:2+int(ud[1])]), "\x00")
+	}
 
-	ipp := "ip"
-	if isV6 {
-		ipp = "ip6"
+	return strip(string(ud))
+}
+
+func findChain(nft *nftables.Conn, device string) (*nftables.Table, *nftables.Chain, error) {
+	chains, err := nft.ListChainsOfTableFamily(nftables.TableFamilyNetdev)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	args := []string{"add", "rule", "netdev", table.Name, chain.Name,
-		ipp, "saddr", snet.String(),
-		ipp, "daddr", dnet.String(),
-		"counter",
-		"accept",
-		"comment", comment,
+	for _, c := range chains {
+		if c.Name == device && c.Table.Name == nftTable {
+			return c.Table, c, nil
+		}
 	}
 
-	slog.Info("adding rule", "nft", args)
+	return nil, nil, ErrNoChain
+}
 
-	cmd := exec.CommandContext(ctx, "nft", args...)
+func checkOrCreateTable(nft *nftables.Conn) (*nftables.Table, error) {
+	tables, err := nft.ListTables()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		if t.Name == nftTable {
+			return t, nil
+		}
+	}
+
+	return nft.AddTable(&nftables.Table{
+		Family: nftables.TableFamilyNetdev,
+		Name:   nftTable,
+	}), nft.Flush()
+}
+
+func checkOrCreateWGAIngressChain(ctx context.Context, nft *nftables.Conn, table *nftables.Table, deviceName string) (
+	*nftables.Chain, error,
+) {
+	chains, err := nft.ListChains()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range chains {
+		if c.Name == deviceName && c.Table.Name == table.Name {
+			return c, nil
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "nft", "add", "chain", "netdev", table.Name, deviceName, "{ type filter hook ingress device "+deviceName+" priority 0 ; policy drop; }")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, err
+	}
 
-	err := cmd.Run()
+	chains, err = nft.ListChains()
 	if err != nil {
-		exitError := &exec.ExitError{}
-		if errors.As(err, &exitError) {
-			return fmt.Errorf("nftables error: %s", string(exitError.Stderr))
-		}
+		return nil, err
+	}
 
-		return err
+	for _, c := range chains {
+		if c.Name == deviceName && c.Table.Name == table.Name {
+			return c, nil
+		}
 	}
 
-	return nil
+	return nil, nil
 }
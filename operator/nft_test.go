@@ -0,0 +1,90 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+)
+
+func TestNFTSync(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice, bob := mustKey(t).PublicKey(), mustKey(t).PublicKey()
+	cfg := &Config{
+		Rules: []v1beta.WireguardAccessRule{
+			testRule("intranet", "fd00:2::/64", "10.2.0.0/16"),
+			testRule("broken", "not a cidr"),
+		},
+		Peers: []v1beta.WireguardAccessPeer{
+			testPeer("alice", alice, []string{"intranet", "broken"}, "fd00:1::1"),
+			testPeer("bob", bob, []string{"intranet", "unknown"}, "fd00:1::2", "10.0.0.2"),
+		},
+	}
+
+	if err := nftSync(ctx, testLog, dp, cfg, DEVICENAME); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := dp.FilterRules(ctx, DEVICENAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, r := range rules {
+		got = append(got, r.Source.String()+" "+r.Destination+" "+r.Protocol)
+	}
+
+	want := []string{
+		"fd00:1::1/128 fd00:2::/64 ",
+		"fd00:1::1/128 fd00::53 udp",
+		"fd00:1::1/128 fd00::53 tcp",
+		"fd00:1::2/128 fd00:2::/64 ",
+		"fd00:1::2/128 fd00::53 udp",
+		"fd00:1::2/128 fd00::53 tcp",
+		"10.0.0.2/32 10.2.0.0/16 ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected rules\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// nothing changed, nothing to do
+	dp.Reset()
+	if err := nftSync(ctx, testLog, dp, cfg, DEVICENAME); err != nil {
+		t.Fatal(err)
+	}
+	if len(dp.Changes()) != 0 {
+		t.Errorf("unexpected changes on resync: %v", dp.Changes())
+	}
+
+	// alice loses access to the intranet
+	cfg.Peers[0].Spec.AccessRules = nil
+	if err := nftSync(ctx, testLog, dp, cfg, DEVICENAME); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, _ = dp.FilterRules(ctx, DEVICENAME)
+	for _, r := range rules {
+		if r.Source.String() == "fd00:1::1/128" && r.Destination == "fd00:2::/64" {
+			t.Errorf("stale rule left: %s", r)
+		}
+	}
+	if len(rules) != len(want)-1 {
+		t.Errorf("expected %d rules, got %d", len(want)-1, len(rules))
+	}
+}
+
+func TestRuleComment(t *testing.T) {
+	comment := "rfd0011128fd00264"
+
+	// userdata as written by the nft cli: type 0, length, NUL terminated comment
+	ud := append([]byte{0, byte(len(comment) + 1)}, comment...)
+	ud = append(ud, 0)
+
+	if got := ruleComment(ud); got != comment {
+		t.Errorf("ruleComment() = %q, want %q", got, comment)
+	}
+}
@@ -0,0 +1,205 @@
+package operator
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	PlanAdd    = "add"
+	PlanUpdate = "update"
+	PlanRemove = "remove"
+)
+
+// desiredPeer is a peer as it should be configured on the device.
+type desiredPeer struct {
+	// Name of the WireguardAccessPeer, for logging
+	Name   string
+	Config wgtypes.PeerConfig
+}
+
+// peerChange is a single entry of a peerPlan.
+type peerChange struct {
+	Action string
+	Name   string
+	Key    wgtypes.Key
+	// Fields lists what differs between the device and the desired state for updates.
+	Fields []string
+}
+
+func (c peerChange) String() string {
+	s := c.Action + " "
+	if c.Name != "" {
+		s += c.Name
+	} else {
+		s += c.Key.String()
+	}
+
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ",") + ")"
+	}
+	return s
+}
+
+// peerPlan is the set of changes that moves a device from its actual to the desired peers.
+type peerPlan struct {
+	Changes []peerChange
+	Config  wgtypes.Config
+}
+
+func (p peerPlan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+func (p peerPlan) count(action string) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Log writes the whole plan as a single structured record.
+func (p peerPlan) Log(log *slog.Logger) {
+	if p.Empty() {
+		log.Debug("peer plan: nothing to do")
+		return
+	}
+
+	changes := make([]string, 0, len(p.Changes))
+	for _, c := range p.Changes {
+		changes = append(changes, c.String())
+	}
+
+	log.Info("peer plan",
+		"add", p.count(PlanAdd),
+		"update", p.count(PlanUpdate),
+		"remove", p.count(PlanRemove),
+		"changes", changes,
+	)
+}
+
+// planPeers compares the desired peers against the peers on the device.
+// Allowed IPs are compared as sets. Preshared key, keepalive and endpoint are
+// only compared when the desired config sets them. Updates only carry the
+// fields that differ.
+func planPeers(desired map[wgtypes.Key]desiredPeer, actual []wgtypes.Peer) peerPlan {
+	plan := peerPlan{}
+
+	seen := make(map[wgtypes.Key]bool, len(actual))
+	for _, have := range actual {
+		seen[have.PublicKey] = true
+
+		want, ok := desired[have.PublicKey]
+		if !ok {
+			plan.Changes = append(plan.Changes, peerChange{
+				Action: PlanRemove,
+				Key:    have.PublicKey,
+			})
+			plan.Config.Peers = append(plan.Config.Peers, wgtypes.PeerConfig{
+				PublicKey: have.PublicKey,
+				Remove:    true,
+			})
+			continue
+		}
+
+		update, fields := diffPeer(want.Config, have)
+		if len(fields) == 0 {
+			continue
+		}
+
+		plan.Changes = append(plan.Changes, peerChange{
+			Action: PlanUpdate,
+			Name:   want.Name,
+			Key:    have.PublicKey,
+			Fields: fields,
+		})
+		plan.Config.Peers = append(plan.Config.Peers, update)
+	}
+
+	added := []wgtypes.Key{}
+	for k := range desired {
+		if !seen[k] {
+			added = append(added, k)
+		}
+	}
+	slices.SortFunc(added, func(a, b wgtypes.Key) int {
+		return strings.Compare(desired[a].Name, desired[b].Name)
+	})
+
+	for _, k := range added {
+		plan.Changes = append(plan.Changes, peerChange{
+			Action: PlanAdd,
+			Name:   desired[k].Name,
+			Key:    k,
+		})
+		plan.Config.Peers = append(plan.Config.Peers, desired[k].Config)
+	}
+
+	return plan
+}
+
+// diffPeer returns the minimal update from have to want, and the names of the fields that differ.
+func diffPeer(want wgtypes.PeerConfig, have wgtypes.Peer) (wgtypes.PeerConfig, []string) {
+	update := wgtypes.PeerConfig{
+		PublicKey:  have.PublicKey,
+		UpdateOnly: true,
+	}
+	fields := []string{}
+
+	if !sameIPNets(want.AllowedIPs, have.AllowedIPs) {
+		update.ReplaceAllowedIPs = true
+		update.AllowedIPs = want.AllowedIPs
+		fields = append(fields, "allowedIPs")
+	}
+
+	if want.PresharedKey != nil && *want.PresharedKey != have.PresharedKey {
+		update.PresharedKey = want.PresharedKey
+		fields = append(fields, "presharedKey")
+	}
+
+	if want.PersistentKeepaliveInterval != nil && *want.PersistentKeepaliveInterval != have.PersistentKeepaliveInterval {
+		update.PersistentKeepaliveInterval = want.PersistentKeepaliveInterval
+		fields = append(fields, "keepalive")
+	}
+
+	if want.Endpoint != nil && (have.Endpoint == nil || want.Endpoint.String() != have.Endpoint.String()) {
+		update.Endpoint = want.Endpoint
+		fields = append(fields, "endpoint")
+	}
+
+	return update, fields
+}
+
+func sameIPNets(a, b []net.IPNet) bool {
+	set := make(map[string]int, len(a))
+	for _, n := range a {
+		set[canonicalNet(n)]++
+	}
+
+	for _, n := range b {
+		k := canonicalNet(n)
+		if set[k] == 0 {
+			return false
+		}
+		set[k]--
+	}
+
+	for _, n := range set {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalNet formats a network independent of the ip representation (4 vs 16 bytes).
+func canonicalNet(n net.IPNet) string {
+	return n.String()
+}
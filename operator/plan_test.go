@@ -0,0 +1,118 @@
+package operator
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestPlanPeers(t *testing.T) {
+	alice, bob, carol := mustKey(t).PublicKey(), mustKey(t).PublicKey(), mustKey(t).PublicKey()
+	psk := mustKey(t)
+	keepalive := 60 * time.Second
+	a1, a2 := mustCIDR(t, "fd00:1::1/128"), mustCIDR(t, "10.0.0.1/32")
+
+	desired := map[wgtypes.Key]desiredPeer{
+		alice: {Name: "alice", Config: wgtypes.PeerConfig{
+			PublicKey:                   alice,
+			PresharedKey:                &psk,
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  []net.IPNet{a1, a2},
+		}},
+		carol: {Name: "carol", Config: wgtypes.PeerConfig{
+			PublicKey:  carol,
+			AllowedIPs: []net.IPNet{mustCIDR(t, "fd00:1::3/128")},
+		}},
+	}
+
+	t.Run("reordered allowed ips are unchanged", func(t *testing.T) {
+		plan := planPeers(desired, []wgtypes.Peer{
+			{PublicKey: alice, PresharedKey: psk, PersistentKeepaliveInterval: keepalive, AllowedIPs: []net.IPNet{a2, a1}},
+			{PublicKey: carol, AllowedIPs: []net.IPNet{mustCIDR(t, "fd00:1::3/128")}},
+		})
+		if !plan.Empty() {
+			t.Errorf("expected empty plan, got %v", plan.Changes)
+		}
+	})
+
+	t.Run("keepalive change is a minimal update", func(t *testing.T) {
+		plan := planPeers(desired, []wgtypes.Peer{
+			{PublicKey: alice, PresharedKey: psk, PersistentKeepaliveInterval: 25 * time.Second, AllowedIPs: []net.IPNet{a1, a2}},
+			{PublicKey: carol, AllowedIPs: []net.IPNet{mustCIDR(t, "fd00:1::3/128")}},
+		})
+		if len(plan.Changes) != 1 || plan.Changes[0].String() != "update alice (keepalive)" {
+			t.Fatalf("unexpected plan %v", plan.Changes)
+		}
+
+		pc := plan.Config.Peers[0]
+		if !pc.UpdateOnly || pc.ReplaceAllowedIPs || pc.AllowedIPs != nil || pc.PresharedKey != nil {
+			t.Errorf("update carries more than the keepalive: %+v", pc)
+		}
+		if pc.PersistentKeepaliveInterval == nil || *pc.PersistentKeepaliveInterval != keepalive {
+			t.Errorf("keepalive not updated: %+v", pc)
+		}
+	})
+
+	t.Run("psk and allowed ips", func(t *testing.T) {
+		plan := planPeers(desired, []wgtypes.Peer{
+			{PublicKey: alice, PersistentKeepaliveInterval: keepalive, AllowedIPs: []net.IPNet{a1}},
+			{PublicKey: carol, AllowedIPs: []net.IPNet{mustCIDR(t, "fd00:1::3/128")}},
+		})
+		if len(plan.Changes) != 1 || plan.Changes[0].String() != "update alice (allowedIPs,presharedKey)" {
+			t.Fatalf("unexpected plan %v", plan.Changes)
+		}
+
+		pc := plan.Config.Peers[0]
+		if !pc.ReplaceAllowedIPs || len(pc.AllowedIPs) != 2 || pc.PresharedKey == nil {
+			t.Errorf("unexpected update %+v", pc)
+		}
+	})
+
+	t.Run("add and remove", func(t *testing.T) {
+		plan := planPeers(desired, []wgtypes.Peer{
+			{PublicKey: bob},
+		})
+
+		got := []string{}
+		for _, c := range plan.Changes {
+			got = append(got, c.Action+" "+c.Key.String())
+		}
+		want := []string{
+			"remove " + bob.String(),
+			"add " + alice.String(),
+			"add " + carol.String(),
+		}
+		if len(got) != len(want) {
+			t.Fatalf("unexpected plan %v", got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("change %d: got %s, want %s", i, got[i], want[i])
+			}
+		}
+
+		if !plan.Config.Peers[0].Remove {
+			t.Error("bob is not removed")
+		}
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		ep := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51820}
+		want := map[wgtypes.Key]desiredPeer{
+			bob: {Name: "bob", Config: wgtypes.PeerConfig{PublicKey: bob, Endpoint: ep}},
+		}
+
+		plan := planPeers(want, []wgtypes.Peer{{PublicKey: bob, Endpoint: ep}})
+		if !plan.Empty() {
+			t.Errorf("expected empty plan, got %v", plan.Changes)
+		}
+
+		plan = planPeers(want, []wgtypes.Peer{{PublicKey: bob}})
+		if len(plan.Changes) != 1 || plan.Changes[0].String() != "update bob (endpoint)" {
+			t.Errorf("unexpected plan %v", plan.Changes)
+		}
+	})
+}
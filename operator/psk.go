@@ -0,0 +1,223 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// withNamespace defaults the namespace of a secret reference.
+func withNamespace(ref corev1.SecretReference, namespace string) corev1.SecretReference {
+	if ref.Namespace == "" {
+		ref.Namespace = namespace
+	}
+
+	return ref
+}
+
+// peerPreSharedKey returns the preshared key of a peer, or the zero key if it has none.
+// Inline keys are still honored until they are migrated. A reference without namespace points into namespace.
+func peerPreSharedKey(ctx context.Context, c client.Reader, peer *v1beta.WireguardAccessPeer, namespace string) (wgtypes.Key, error) {
+	if peer.Spec.PreSharedKey != "" {
+		psk, err := wgtypes.ParseKey(peer.Spec.PreSharedKey)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("error parsing preshared key: %w", err)
+		}
+
+		return psk, nil
+	}
+
+	if peer.Spec.PreSharedKeySecretRef == nil {
+		return wgtypes.Key{}, nil
+	}
+
+	return secretKey(ctx, c, withNamespace(*peer.Spec.PreSharedKeySecretRef, namespace), SecretKeyPreSharedKey)
+}
+
+// resolvePreSharedKeys returns the peers with the preshared keys from their secrets filled in, for use by the dataplane only.
+// Peers whose key can't be read are left out rather than configured without it.
+func resolvePreSharedKeys(ctx context.Context, c client.Reader, peers []v1beta.WireguardAccessPeer) []v1beta.WireguardAccessPeer {
+	resolved := make([]v1beta.WireguardAccessPeer, 0, len(peers))
+	for _, peer := range peers {
+		if peer.Spec.PreSharedKey == "" && peer.Spec.PreSharedKeySecretRef != nil {
+			psk, err := peerPreSharedKey(ctx, c, &peer, getK8sNamespace())
+			if err != nil {
+				slog.Warn("skipping peer without preshared key", "peer", peer.Name, "err", err)
+				continue
+			}
+
+			peer.Spec.PreSharedKey = psk.String()
+		}
+
+		resolved = append(resolved, peer)
+	}
+
+	return resolved
+}
+
+// resolveStatusPreSharedKeys returns a copy of the peer with the preshared keys of its server peers filled in.
+func resolveStatusPreSharedKeys(ctx context.Context, c client.Reader, peer *v1beta.WireguardAccessPeer, namespace string) (*v1beta.WireguardAccessPeer, error) {
+	peer = peer.DeepCopy()
+	for i, p := range peer.Status.Peers {
+		if p.PreSharedKey != "" || p.PreSharedKeySecretRef == nil {
+			continue
+		}
+
+		psk, err := secretKey(ctx, c, withNamespace(*p.PreSharedKeySecretRef, namespace), SecretKeyPreSharedKey)
+		if err != nil {
+			return nil, err
+		}
+
+		peer.Status.Peers[i].PreSharedKey = psk.String()
+	}
+
+	return peer, nil
+}
+
+// migratePreSharedKey moves an inline preshared key into the peer's secret, so it can no longer be read from the CRD.
+func (r *PeerReconciler) migratePreSharedKey(ctx context.Context, peer *v1beta.WireguardAccessPeer) error {
+	psk, err := wgtypes.ParseKey(peer.Spec.PreSharedKey)
+	if err != nil {
+		return fmt.Errorf("error parsing preshared key: %w", err)
+	}
+
+	ref := peerSecretRef(peer)
+	if peer.Spec.PreSharedKeySecretRef != nil {
+		ref = withNamespace(*peer.Spec.PreSharedKeySecretRef, getK8sNamespace())
+	}
+
+	err = storeSecretKey(ctx, r.client, ref, SecretKeyPreSharedKey, []byte(psk.String()), map[string]string{LabelPeer: peer.Name}, nil, peer)
+	if err != nil {
+		return err
+	}
+
+	r.log.Info("moved preshared key of peer into secret", "peer", peer.Name, "secret", ref.Namespace+"/"+ref.Name)
+
+	patch := client.MergeFromWithOptions(peer.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	peer.Spec.PreSharedKey = ""
+	peer.Spec.PreSharedKeySecretRef = &ref
+
+	err = r.client.Patch(ctx, peer, patch)
+	if err != nil {
+		return err
+	}
+
+	r.recorder.Eventf(peer, corev1.EventTypeNormal, "PreSharedKeyMigrated", "moved preshared key into secret %s/%s", ref.Namespace, ref.Name)
+
+	return nil
+}
+
+// migrateStatusPreSharedKeys moves the inline preshared keys of the peer's server peers into secrets, one per server peer.
+// Invalid keys stay inline, like in the spec. The status is patched right away, the caller continues with the patched peer.
+func (r *PeerReconciler) migrateStatusPreSharedKeys(ctx context.Context, peer *v1beta.WireguardAccessPeer) error {
+	if peer.Status == nil {
+		return nil
+	}
+
+	patch := client.MergeFromWithOptions(peer.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	migrated := []string{}
+	for i, p := range peer.Status.Peers {
+		if p.PreSharedKey == "" {
+			continue
+		}
+
+		psk, err := wgtypes.ParseKey(p.PreSharedKey)
+		if err != nil {
+			continue
+		}
+
+		ref := corev1.SecretReference{Name: fmt.Sprintf("wgap-%s-server-%d", peer.Name, i)}
+		if p.PreSharedKeySecretRef != nil {
+			ref = *p.PreSharedKeySecretRef
+		}
+		// explicit, clients outside the endpoint's namespace resolve it too
+		ref = withNamespace(ref, getK8sNamespace())
+
+		err = storeSecretKey(ctx, r.client, ref, SecretKeyPreSharedKey, []byte(psk.String()), map[string]string{LabelPeer: peer.Name}, nil, peer)
+		if err != nil {
+			return err
+		}
+
+		peer.Status.Peers[i].PreSharedKey = ""
+		peer.Status.Peers[i].PreSharedKeySecretRef = &ref
+		migrated = append(migrated, ref.Namespace+"/"+ref.Name)
+	}
+
+	if len(migrated) == 0 {
+		return nil
+	}
+
+	err := r.client.Status().Patch(ctx, peer, patch)
+	if err != nil {
+		return err
+	}
+
+	r.log.Info("moved preshared keys of server peers into secrets", "peer", peer.Name, "secrets", migrated)
+	r.recorder.Eventf(peer, corev1.EventTypeNormal, "PreSharedKeyMigrated", "moved preshared keys of server peers into secrets %s", strings.Join(migrated, ", "))
+
+	return nil
+}
+
+// nodePreSharedKeyRef returns where the preshared key of a cluster client node is kept,
+// next to its private key unless configured otherwise.
+func nodePreSharedKeyRef(wgc *v1beta.WireguardClusterClient, node v1beta.WireguardClusterClientNode) corev1.SecretReference {
+	ref := corev1.SecretReference{Name: formatSecretName(node.NodeName, wgc.Name)}
+	if node.PreSharedKeySecretRef != nil {
+		ref = *node.PreSharedKeySecretRef
+	}
+
+	return withNamespace(ref, getK8sNamespace())
+}
+
+// nodePreSharedKey returns the preshared key of a cluster client node, or "" if it has none.
+func nodePreSharedKey(ctx context.Context, c client.Reader, wgc *v1beta.WireguardClusterClient, node v1beta.WireguardClusterClientNode) (string, error) {
+	if node.PreSharedKey != "" || node.PreSharedKeySecretRef == nil {
+		return node.PreSharedKey, nil
+	}
+
+	psk, err := secretKey(ctx, c, nodePreSharedKeyRef(wgc, node), SecretKeyPreSharedKey)
+	if err != nil {
+		return "", fmt.Errorf("error reading preshared key: %w", err)
+	}
+
+	return psk.String(), nil
+}
+
+// migratePreSharedKey moves the inline preshared key of this node into a secret.
+// Every node migrates its own entry, the optimistic lock keeps them from overwriting each other.
+func (r *ClusterClientReconciler) migratePreSharedKey(ctx context.Context, wgc *v1beta.WireguardClusterClient, nodeName string) error {
+	for i, node := range wgc.Spec.Nodes {
+		if node.NodeName != nodeName {
+			continue
+		}
+
+		ref := nodePreSharedKeyRef(wgc, node)
+		err := storeSecretKey(ctx, r.client, ref, SecretKeyPreSharedKey, []byte(node.PreSharedKey), nil, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		r.log.Info("moved preshared key of cluster client node into secret", "name", wgc.Name, "node", nodeName, "secret", ref.Namespace+"/"+ref.Name)
+
+		patch := client.MergeFromWithOptions(wgc.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		wgc.Spec.Nodes[i].PreSharedKey = ""
+		wgc.Spec.Nodes[i].PreSharedKeySecretRef = &ref
+
+		err = r.client.Patch(ctx, wgc, patch)
+		if err != nil {
+			return err
+		}
+
+		r.recorder.Eventf(wgc, corev1.EventTypeNormal, "PreSharedKeyMigrated", "moved preshared key of node %s into secret %s/%s", nodeName, ref.Namespace, ref.Name)
+
+		return nil
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// revokedKeys returns the revoked public keys, mapped to the revocation that lists them.
+func revokedKeys(ctx context.Context, c client.Reader) (map[string]string, error) {
+	revs := new(v1beta.WireguardAccessRevocationList)
+	err := c.List(ctx, revs)
+	if err != nil {
+		return nil, fmt.Errorf("error listing revocations: %w", err)
+	}
+
+	revoked := make(map[string]string, len(revs.Items))
+	for _, rev := range revs.Items {
+		revoked[rev.Spec.PublicKey] = rev.Name
+	}
+
+	return revoked, nil
+}
+
+// revocationFor returns the revocation listing the public key, or nil.
+func revocationFor(ctx context.Context, c client.Reader, publicKey string) (*v1beta.WireguardAccessRevocation, error) {
+	revs := new(v1beta.WireguardAccessRevocationList)
+	err := c.List(ctx, revs)
+	if err != nil {
+		return nil, fmt.Errorf("error listing revocations: %w", err)
+	}
+
+	for _, rev := range revs.Items {
+		if rev.Spec.PublicKey == publicKey {
+			return &rev, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// peersWithKey returns requests for all peers using the public key.
+func peersWithKey(ctx context.Context, c client.Reader, publicKey string) []reconcile.Request {
+	peers := new(v1beta.WireguardAccessPeerList)
+	err := c.List(ctx, peers)
+	if err != nil {
+		slog.Error("Error listing peers", "error", err)
+		return nil
+	}
+
+	reqs := []reconcile.Request{}
+	for _, p := range peers.Items {
+		if p.Spec.PublicKey == publicKey {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&p)})
+		}
+	}
+
+	return reqs
+}
+
+// setRevokedCondition flags a peer whose key is revoked and reports whether the condition changed.
+// Peers that were never revoked don't get the condition at all.
+func setRevokedCondition(peer *v1beta.WireguardAccessPeer, rev *v1beta.WireguardAccessRevocation) bool {
+	if rev == nil {
+		if meta.FindStatusCondition(peer.Status.Conditions, v1beta.ConditionRevoked) == nil {
+			return false
+		}
+
+		return setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionRevoked, false, "NotRevoked", "")
+	}
+
+	message := "public key revoked by " + rev.Name
+	if rev.Spec.Reason != "" {
+		message += ": " + rev.Spec.Reason
+	}
+
+	return setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionRevoked, true, "Revoked", message)
+}
@@ -0,0 +1,83 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationPSKRotatedAt records on the secret when the endpoint last rotated the preshared key in it.
+// It is written together with the key, so a lost status update can't get a key rotated twice.
+const AnnotationPSKRotatedAt = "wga.kraudcloud.com/psk-rotated-at"
+
+// pskRotationInterval returns how often the preshared key of a peer is replaced, zero for never.
+func (p PeerPolicy) pskRotationInterval(peer *v1beta.WireguardAccessPeer) time.Duration {
+	if peer.Spec.PreSharedKeyRotationInterval != nil {
+		return peer.Spec.PreSharedKeyRotationInterval.Duration
+	}
+
+	return p.PSKRotationInterval
+}
+
+// nextPSKRotation returns when the preshared key of a peer is due, zero if it is never rotated.
+// Only keys kept in a secret are rotated, that is where clients pick up the new one.
+// The last rotation is the latest of the peer's creation, its status and the secret's annotation.
+func (r *PeerReconciler) nextPSKRotation(ctx context.Context, peer *v1beta.WireguardAccessPeer) (time.Time, error) {
+	interval := r.policy.pskRotationInterval(peer)
+	if interval <= 0 || peer.Spec.PreSharedKeySecretRef == nil {
+		return time.Time{}, nil
+	}
+
+	last := peer.CreationTimestamp.Time
+	if peer.Status != nil && peer.Status.PreSharedKeyRotatedAt != nil && peer.Status.PreSharedKeyRotatedAt.After(last) {
+		last = peer.Status.PreSharedKeyRotatedAt.Time
+	}
+
+	ref := withNamespace(*peer.Spec.PreSharedKeySecretRef, getK8sNamespace())
+	sk := corev1.Secret{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &sk)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return time.Time{}, fmt.Errorf("error getting secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	if at, err := time.Parse(time.RFC3339, sk.Annotations[AnnotationPSKRotatedAt]); err == nil && at.After(last) {
+		last = at
+	}
+
+	return last.Add(interval), nil
+}
+
+// rotatePreSharedKey replaces the preshared key of a peer in its secret when it is due and reports whether it did.
+// The new key reaches the device through the secret, the rendered config is updated with it.
+func (r *PeerReconciler) rotatePreSharedKey(ctx context.Context, peer *v1beta.WireguardAccessPeer, now time.Time) (bool, error) {
+	next, err := r.nextPSKRotation(ctx, peer)
+	if err != nil || next.IsZero() || now.Before(next) {
+		return false, err
+	}
+
+	psk, err := wgtypes.GenerateKey()
+	if err != nil {
+		return false, fmt.Errorf("error generating preshared key: %w", err)
+	}
+
+	ref := withNamespace(*peer.Spec.PreSharedKeySecretRef, getK8sNamespace())
+	err = storeSecretKey(ctx, r.client, ref, SecretKeyPreSharedKey, []byte(psk.String()),
+		map[string]string{LabelPeer: peer.Name},
+		map[string]string{AnnotationPSKRotatedAt: now.UTC().Format(time.RFC3339)},
+		peer)
+	if err != nil {
+		return false, err
+	}
+
+	r.log.Info("rotated preshared key of peer", "peer", peer.Name, "secret", ref.Namespace+"/"+ref.Name)
+	r.recorder.Eventf(peer, corev1.EventTypeNormal, "PreSharedKeyRotated", "new preshared key stored in secret %s/%s", ref.Namespace, ref.Name)
+	peer.Status.PreSharedKeyRotatedAt = &metav1.Time{Time: now}
+
+	return true, nil
+}
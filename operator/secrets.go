@@ -0,0 +1,126 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// SecretKeyPreSharedKey holds a preshared key in a secret referenced by preSharedKeySecretRef.
+const SecretKeyPreSharedKey = "preSharedKey"
+
+var errMissingSecretKey = errors.New("key not found in secret")
+
+// peerSecretSelector matches the secrets labelled with LabelPeer, the only ones the endpoint watches.
+func peerSecretSelector() labels.Selector {
+	req, err := labels.NewRequirement(LabelPeer, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return labels.NewSelector().Add(*req)
+}
+
+// secretKey reads a wireguard key from a secret.
+// A missing secret is reported as NotFound, a missing key as errMissingSecretKey.
+func secretKey(ctx context.Context, c client.Reader, ref corev1.SecretReference, key string) (wgtypes.Key, error) {
+	sk := new(corev1.Secret)
+	err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, sk)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("error getting secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	data, ok := sk.Data[key]
+	if !ok {
+		return wgtypes.Key{}, fmt.Errorf("%s in secret %s/%s: %w", key, ref.Namespace, ref.Name, errMissingSecretKey)
+	}
+
+	k, err := wgtypes.ParseKey(string(data))
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("error parsing %s in secret %s/%s: %w", key, ref.Namespace, ref.Name, err)
+	}
+
+	return k, nil
+}
+
+// missingSecretKey reports whether err means the secret or the key in it doesn't exist yet.
+func missingSecretKey(err error) bool {
+	return apierrors.IsNotFound(err) || errors.Is(err, errMissingSecretKey)
+}
+
+// storeSecretKey sets a key and annotations in a secret, creating the secret with the given labels and owner if it doesn't exist.
+// owner may be nil for secrets that outlive the object using them.
+func storeSecretKey(ctx context.Context, c client.Client, ref corev1.SecretReference, key string, value []byte, labels, annotations map[string]string, owner client.Object) error {
+	sk := new(corev1.Secret)
+	err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, sk)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	if apierrors.IsNotFound(err) {
+		sk = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   ref.Namespace,
+				Name:        ref.Name,
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Data: map[string][]byte{
+				key: value,
+			},
+		}
+
+		if owner != nil {
+			err = controllerutil.SetOwnerReference(owner, sk, c.Scheme())
+			if err != nil {
+				return fmt.Errorf("error setting owner of secret: %w", err)
+			}
+		}
+
+		err = c.Create(ctx, sk)
+		if err != nil {
+			return fmt.Errorf("error creating secret %s/%s: %w", ref.Namespace, ref.Name, err)
+		}
+
+		return nil
+	}
+
+	if string(sk.Data[key]) == string(value) && hasAnnotations(sk, annotations) {
+		return nil
+	}
+
+	patch := client.MergeFrom(sk.DeepCopy())
+	if sk.Data == nil {
+		sk.Data = map[string][]byte{}
+	}
+	sk.Data[key] = value
+	for k, v := range annotations {
+		metav1.SetMetaDataAnnotation(&sk.ObjectMeta, k, v)
+	}
+
+	err = c.Patch(ctx, sk, patch)
+	if err != nil {
+		return fmt.Errorf("error updating secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	return nil
+}
+
+func hasAnnotations(o metav1.Object, annotations map[string]string) bool {
+	for k, v := range annotations {
+		if o.GetAnnotations()[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
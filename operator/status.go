@@ -0,0 +1,229 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.org/x/time/rate"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// StatusInterval is how often the wg device is polled for session state.
+	StatusInterval = 30 * time.Second
+	// StatusMinWriteInterval is the minimum time between two status writes of the same peer,
+	// unless the peer's remote endpoint changed.
+	StatusMinWriteInterval = 5 * time.Minute
+)
+
+// statusWriter periodically copies the handshake and transfer counters of
+// the wg device into the status of the matching WireguardAccessPeers,
+// and marks peers without a recent handshake as idle.
+type statusWriter struct {
+	client  client.Client
+	syncer  *Syncer
+	dp      Dataplane
+	log     *slog.Logger
+	limiter *rate.Limiter
+	policy  PeerPolicy
+}
+
+func registerStatusWriter(mgr manager.Manager, syncer *Syncer, dp Dataplane, policy PeerPolicy, log *slog.Logger) {
+	w := &statusWriter{
+		client:  mgr.GetClient(),
+		syncer:  syncer,
+		dp:      dp,
+		policy:  policy,
+		log:     log.With("component", "status-writer"),
+		limiter: rate.NewLimiter(rate.Limit(5), 10),
+	}
+
+	err := mgr.Add(manager.RunnableFunc(w.Start))
+	if err != nil {
+		log.Error("Error creating status writer", "error", err)
+		os.Exit(1)
+	}
+}
+
+func (w *statusWriter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(StatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := w.sync(ctx)
+			if err != nil {
+				w.log.Error("Error writing peer status", "error", err)
+			}
+		}
+	}
+}
+
+func (w *statusWriter) sync(ctx context.Context) error {
+	device, err := w.dp.Device(DEVICENAME)
+	if err != nil {
+		return fmt.Errorf("wg.Device(%s): %w", DEVICENAME, err)
+	}
+
+	havePeers := make(map[string]wgtypes.Peer, len(device.Peers))
+	for _, p := range device.Peers {
+		havePeers[p.PublicKey.String()] = p
+	}
+	recordHandshakes(device.Peers)
+
+	peers := new(v1beta.WireguardAccessPeerList)
+	err = w.client.List(ctx, peers)
+	if err != nil {
+		return fmt.Errorf("error listing peers: %w", err)
+	}
+
+	now := time.Now()
+	states := map[string]int{
+		PeerStateActive:  0,
+		PeerStateIdle:    0,
+		PeerStateNever:   0,
+		PeerStatePending: 0,
+	}
+	peerReceiveBytes.Reset()
+	peerTransmitBytes.Reset()
+	defer func() {
+		for state, n := range states {
+			peersByState.WithLabelValues(state).Set(float64(n))
+		}
+	}()
+
+	candidates := []idleCandidate{}
+	for _, peer := range peers.Items {
+		if peer.Status == nil {
+			states[PeerStatePending]++
+			continue
+		}
+
+		// peers come from the cache, the lock keeps a stale copy from overwriting conditions written since
+		patch := client.MergeFromWithOptions(peer.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		changed := false
+
+		have, ok := havePeers[peer.Spec.PublicKey]
+		if ok {
+			states[handshakeState(have.LastHandshakeTime, now)]++
+			if !have.LastHandshakeTime.IsZero() {
+				peerHandshakeAge.Observe(now.Sub(have.LastHandshakeTime).Seconds())
+			}
+			peerReceiveBytes.WithLabelValues(peer.Name).Set(float64(have.ReceiveBytes))
+			peerTransmitBytes.WithLabelValues(peer.Name).Set(float64(have.TransmitBytes))
+
+			conn := connectionStatus(have, now)
+			if connectionChanged(peer.Status.Connection, conn) {
+				peer.Status.Connection = conn
+				changed = true
+			}
+		} else {
+			states[PeerStatePending]++
+		}
+
+		if seen := peerLastSeen(&peer); lastSeenChanged(peer.Status.LastSeen, seen) {
+			peer.Status.LastSeen = &metav1.Time{Time: seen}
+			changed = true
+		}
+
+		var candidate *idleCandidate
+		if w.policy.IdleThreshold > 0 {
+			reported := wasIdle(&peer)
+			if setIdleCondition(&peer, now, w.policy.IdleThreshold) {
+				changed = true
+			}
+
+			if cond := meta.FindStatusCondition(peer.Status.Conditions, v1beta.ConditionIdle); cond != nil && cond.Status == metav1.ConditionTrue {
+				candidate = &idleCandidate{
+					Name:      peer.Name,
+					LastSeen:  peerLastSeen(&peer),
+					Reported:  reported,
+					IdleSince: cond.LastTransitionTime.Time,
+				}
+			}
+		}
+
+		if changed {
+			if err := w.limiter.Wait(ctx); err != nil {
+				return err
+			}
+
+			err := w.client.Status().Patch(ctx, &peer, patch)
+			if apierrors.IsConflict(err) {
+				// the peer changed since it was cached, it is looked at again next round
+				w.log.Debug("peer changed, skipping status update", "peer", peer.Name)
+				continue
+			}
+			if err != nil {
+				w.log.Error("Error patching peer status", "peer", peer.Name, "error", err)
+				continue
+			}
+
+			w.log.Debug("updated peer status", "peer", peer.Name)
+		}
+
+		if candidate != nil {
+			candidates = append(candidates, *candidate)
+		}
+	}
+
+	w.handleIdle(ctx, candidates, now)
+
+	return nil
+}
+
+func connectionStatus(p wgtypes.Peer, now time.Time) *v1beta.WireguardAccessPeerStatusConnection {
+	conn := &v1beta.WireguardAccessPeerStatusConnection{
+		ReceiveBytes:  p.ReceiveBytes,
+		TransmitBytes: p.TransmitBytes,
+		LastUpdated:   metav1.NewTime(now),
+	}
+
+	if !p.LastHandshakeTime.IsZero() {
+		hs := metav1.NewTime(p.LastHandshakeTime)
+		conn.LastHandshake = &hs
+	}
+
+	if p.Endpoint != nil {
+		conn.Endpoint = p.Endpoint.String()
+	}
+
+	return conn
+}
+
+// connectionChanged reports whether the new connection state is worth a write.
+// Endpoint changes and first handshakes are written immediately, everything
+// else at most every StatusMinWriteInterval.
+func connectionChanged(old, nu *v1beta.WireguardAccessPeerStatusConnection) bool {
+	if old == nil {
+		return true
+	}
+
+	if old.Endpoint != nu.Endpoint {
+		return true
+	}
+
+	if (old.LastHandshake == nil) != (nu.LastHandshake == nil) {
+		return true
+	}
+
+	if nu.LastUpdated.Sub(old.LastUpdated.Time) < StatusMinWriteInterval {
+		return false
+	}
+
+	return old.ReceiveBytes != nu.ReceiveBytes ||
+		old.TransmitBytes != nu.TransmitBytes ||
+		!old.LastHandshake.Equal(nu.LastHandshake)
+}
@@ -0,0 +1,290 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SyncDebounce is how long the sync worker waits for more requests before it syncs,
+	// so a burst of peer or rule changes is applied in one go.
+	SyncDebounce = 200 * time.Millisecond
+	// SyncMinBackoff and SyncMaxBackoff bound the delay before a failed sync is retried.
+	SyncMinBackoff = time.Second
+	SyncMaxBackoff = 2 * time.Minute
+	// SyncWaitTimeout bounds how long Sync blocks a reconciler, the sync itself carries on.
+	SyncWaitTimeout = 10 * time.Second
+)
+
+// Stages of WGASync, as reported in SyncError and the wga_sync_errors_total metric.
+const (
+	SyncStageFetch = "fetch"
+	SyncStageWG    = "wg"
+	SyncStageNFT   = "nft"
+)
+
+// SyncError is a failed stage of WGASync.
+type SyncError struct {
+	Stage string
+	Err   error
+}
+
+func (e *SyncError) Error() string {
+	return fmt.Sprintf("error syncing %s: %v", e.Stage, e.Err)
+}
+
+func (e *SyncError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent reports whether retrying won't help, eg. because the endpoint lacks privileges or kernel support.
+// Everything else, like an unreachable api server, is transient.
+func (e *SyncError) Permanent() bool {
+	return errors.Is(e.Err, os.ErrPermission) ||
+		errors.Is(e.Err, syscall.EPERM) ||
+		errors.Is(e.Err, syscall.EOPNOTSUPP) ||
+		errors.Is(e.Err, syscall.EAFNOSUPPORT) ||
+		errors.Is(e.Err, syscall.EPROTONOSUPPORT) ||
+		errors.Is(e.Err, exec.ErrNotFound)
+}
+
+// errSyncPending is returned by Sync when the requested sync didn't finish within SyncWaitTimeout.
+var errSyncPending = errors.New("dataplane sync still pending")
+
+// permanentSyncError reports whether err is a sync failure that retrying won't fix.
+func permanentSyncError(err error) bool {
+	var se *SyncError
+	return errors.As(err, &se) && se.Permanent()
+}
+
+// syncErrorKind labels a sync failure for metrics and conditions.
+func syncErrorKind(err error) string {
+	if permanentSyncError(err) {
+		return "permanent"
+	}
+	return "transient"
+}
+
+// syncFailedReason is the condition reason and event reason for a sync failure.
+func syncFailedReason(err error) string {
+	if errors.Is(err, errSyncPending) {
+		return "SyncPending"
+	}
+	if permanentSyncError(err) {
+		return "SyncFailedPermanently"
+	}
+	return "SyncFailed"
+}
+
+// syncRequeueAfter is when a reconciler checks back after a failed or pending sync,
+// zero if it should return the error to be retried with the controller's backoff.
+func syncRequeueAfter(err error) time.Duration {
+	switch {
+	case errors.Is(err, errSyncPending):
+		// it finishes without the reconciler
+		return SyncMinBackoff
+	case permanentSyncError(err):
+		// the sync worker keeps retrying on its own, check back at its pace
+		return SyncMaxBackoff
+	default:
+		return 0
+	}
+}
+
+// Syncer is the only writer of the endpoint dataplane.
+// Reconcilers request a sync instead of running WGASync themselves,
+// requests that arrive while a sync is pending or running are served by the next one.
+//
+// Every request gets a generation, a sync covers all generations requested before it started.
+type Syncer struct {
+	client   client.Client
+	dp       Dataplane
+	log      *slog.Logger
+	debounce time.Duration
+
+	trigger chan struct{}
+
+	lock      sync.Mutex
+	requested uint64
+	synced    uint64
+	err       error
+	// set while the worker backs off after err
+	backingOff bool
+	// first request not covered by a sync yet, for the latency metric
+	pendingSince time.Time
+	// closed and replaced after every sync
+	done chan struct{}
+}
+
+func newSyncer(c client.Client, dp Dataplane, log *slog.Logger) *Syncer {
+	return &Syncer{
+		client:   c,
+		dp:       dp,
+		log:      log.With("component", "syncer"),
+		debounce: SyncDebounce,
+		trigger:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Request asks for a sync and returns the generation to wait for.
+func (s *Syncer) Request() uint64 {
+	s.lock.Lock()
+	s.requested++
+	gen := s.requested
+	if s.pendingSince.IsZero() {
+		s.pendingSince = time.Now()
+	}
+	s.lock.Unlock()
+
+	syncRequestedGeneration.Set(float64(gen))
+
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+
+	return gen
+}
+
+// Wait blocks until a sync covering gen finished and returns its error.
+func (s *Syncer) Wait(ctx context.Context, gen uint64) error {
+	for {
+		s.lock.Lock()
+		if s.synced >= gen {
+			err := s.err
+			s.lock.Unlock()
+			return err
+		}
+		done := s.done
+		s.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+		}
+	}
+}
+
+// Sync requests a sync and waits for it, at most SyncWaitTimeout.
+// While the worker backs off after a failure, the request is served by the retry
+// and Sync returns the failure right away instead of blocking the caller for the backoff.
+func (s *Syncer) Sync(ctx context.Context) error {
+	gen := s.Request()
+
+	s.lock.Lock()
+	backingOff, err := s.backingOff, s.err
+	s.lock.Unlock()
+	if backingOff {
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, SyncWaitTimeout)
+	defer cancel()
+
+	err = s.Wait(waitCtx, gen)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return errSyncPending
+	}
+
+	return err
+}
+
+// Start runs the sync worker until ctx is done.
+// It syncs once on startup, so the dataplane is set up even without any peers or rules.
+func (s *Syncer) Start(ctx context.Context) error {
+	s.Request()
+
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.trigger:
+		}
+
+		if !sleep(ctx, s.debounce) {
+			return nil
+		}
+
+		err := s.sync()
+		if err == nil {
+			backoff = 0
+			continue
+		}
+
+		backoff = min(max(2*backoff, SyncMinBackoff), SyncMaxBackoff)
+		if permanentSyncError(err) {
+			// still retried, someone may fix the node, but not at the rate of a hiccup
+			backoff = SyncMaxBackoff
+		}
+		s.log.Error("sync failed, retrying", "error", err, "kind", syncErrorKind(err), "retryIn", backoff)
+
+		if !sleep(ctx, backoff) {
+			return nil
+		}
+
+		s.lock.Lock()
+		s.backingOff = false
+		s.lock.Unlock()
+
+		s.Request()
+	}
+}
+
+// sync runs WGASync for all generations requested so far.
+func (s *Syncer) sync() error {
+	s.lock.Lock()
+	gen := s.requested
+	since := s.pendingSince
+	s.pendingSince = time.Time{}
+	s.lock.Unlock()
+
+	err := WGASync(s.client, s.dp, s.log)
+
+	s.lock.Lock()
+	s.synced = gen
+	s.err = err
+	// set with err, so no caller sees the failure without the backoff
+	s.backingOff = err != nil
+	close(s.done)
+	s.done = make(chan struct{})
+	s.lock.Unlock()
+
+	syncGeneration.Set(float64(gen))
+	if err == nil {
+		syncLastSuccess.SetToCurrentTime()
+	}
+	if !since.IsZero() {
+		syncLatency.Observe(time.Since(since).Seconds())
+	}
+
+	return err
+}
+
+// sleep waits for d and reports false if ctx was done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
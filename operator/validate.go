@@ -0,0 +1,118 @@
+package operator
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validatePeerSpec returns everything that keeps a peer from being configured on the endpoint.
+func validatePeerSpec(spec *v1beta.WireguardAccessPeerSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	// an empty public key is filled in by the endpoint
+	if spec.PublicKey != "" {
+		if _, err := wgtypes.ParseKey(spec.PublicKey); err != nil {
+			errs = append(errs, field.Invalid(path.Child("publicKey"), spec.PublicKey, err.Error()))
+		}
+	}
+
+	if spec.PreSharedKey != "" {
+		if _, err := wgtypes.ParseKey(spec.PreSharedKey); err != nil {
+			errs = append(errs, field.Invalid(path.Child("preSharedKey"), "<redacted>", err.Error()))
+		}
+	}
+
+	if i := spec.PreSharedKeyRotationInterval; i != nil && i.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("preSharedKeyRotationInterval"), i.Duration.String(), "must not be negative"))
+	}
+
+	return errs
+}
+
+// validateRuleSpec returns the destinations of a rule that can't be turned into filter rules.
+func validateRuleSpec(spec *v1beta.WireguardAccessRuleSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for i, d := range spec.Destinations {
+		if _, _, err := net.ParseCIDR(d); err != nil {
+			errs = append(errs, field.Invalid(path.Child("destinations").Index(i), d, "must be a CIDR"))
+		}
+	}
+
+	return errs
+}
+
+// validateRevocationSpec returns an error for a revocation that can't match any peer.
+func validateRevocationSpec(spec *v1beta.WireguardAccessRevocationSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if _, err := wgtypes.ParseKey(spec.PublicKey); err != nil {
+		errs = append(errs, field.Invalid(path.Child("publicKey"), spec.PublicKey, err.Error()))
+	}
+
+	return errs
+}
+
+// validateClusterClientSpec returns everything that keeps a cluster client from being configured on its nodes.
+func validateClusterClientSpec(spec *v1beta.WireguardClusterClientSpec, path *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+
+	if _, err := wgtypes.ParseKey(spec.Server.PublicKey); err != nil {
+		errs = append(errs, field.Invalid(path.Child("server", "publicKey"), spec.Server.PublicKey, err.Error()))
+	}
+
+	if _, err := netip.ParseAddrPort(spec.Server.Endpoint); err != nil {
+		errs = append(errs, field.Invalid(path.Child("server", "endpoint"), spec.Server.Endpoint, "must be ip:port"))
+	}
+
+	for i, r := range spec.Routes {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			errs = append(errs, field.Invalid(path.Child("routes").Index(i), r, "must be a CIDR"))
+		}
+	}
+
+	if spec.PersistentKeepalive < 0 || spec.PersistentKeepalive > 65535 {
+		errs = append(errs, field.Invalid(path.Child("persistentKeepalive"), spec.PersistentKeepalive, "must be between 0 and 65535 seconds"))
+	}
+
+	seen := map[string]bool{}
+	for i, n := range spec.Nodes {
+		npath := path.Child("nodes").Index(i)
+
+		if n.NodeName == "" {
+			errs = append(errs, field.Required(npath.Child("nodeName"), "name of the kubernetes node"))
+		} else if seen[n.NodeName] {
+			errs = append(errs, field.Duplicate(npath.Child("nodeName"), n.NodeName))
+		}
+		seen[n.NodeName] = true
+
+		switch {
+		case n.PrivateKey.Value != nil && n.PrivateKey.SecretRef != nil:
+			errs = append(errs, field.Forbidden(npath.Child("privateKey"), "only one of value and secretRef may be set"))
+		case n.PrivateKey.Value == nil && n.PrivateKey.SecretRef == nil:
+			errs = append(errs, field.Required(npath.Child("privateKey"), "one of value and secretRef must be set"))
+		case n.PrivateKey.Value != nil:
+			if _, err := wgtypes.ParseKey(*n.PrivateKey.Value); err != nil {
+				errs = append(errs, field.Invalid(npath.Child("privateKey", "value"), "<redacted>", err.Error()))
+			}
+		}
+
+		if n.PreSharedKey != "" {
+			if _, err := wgtypes.ParseKey(n.PreSharedKey); err != nil {
+				errs = append(errs, field.Invalid(npath.Child("preSharedKey"), "<redacted>", err.Error()))
+			}
+		}
+
+		if net.ParseIP(n.Address) == nil {
+			if _, _, err := net.ParseCIDR(n.Address); err != nil {
+				errs = append(errs, field.Invalid(npath.Child("address"), n.Address, "must be an ip or CIDR"))
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,260 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const DefaultWebhookPort = 9443
+
+// webhookEnabled reports whether admission webhooks are served, which needs WGA_WEBHOOK_CERT_DIR.
+func webhookEnabled() bool {
+	return os.Getenv("WGA_WEBHOOK_CERT_DIR") != ""
+}
+
+func webhookServer() webhook.Server {
+	port := DefaultWebhookPort
+	if p := os.Getenv("WGA_WEBHOOK_PORT"); p != "" {
+		var err error
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			slog.Error("cannot parse webhook port", "WGA_WEBHOOK_PORT", p, "err", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	return webhook.NewServer(webhook.Options{
+		Port:    port,
+		CertDir: os.Getenv("WGA_WEBHOOK_CERT_DIR"),
+	})
+}
+
+// registerWebhooks validates and defaults wga objects at admission time,
+// so mistakes are rejected with a clear message instead of being logged by a sync.
+func registerWebhooks(mgr ctrl.Manager) error {
+	err := ctrl.NewWebhookManagedBy(mgr).
+		For(&v1beta.WireguardAccessPeer{}).
+		WithDefaulter(&peerWebhook{}).
+		WithValidator(&peerWebhook{client: mgr.GetClient()}).
+		Complete()
+	if err != nil {
+		return fmt.Errorf("peer webhook: %w", err)
+	}
+
+	err = ctrl.NewWebhookManagedBy(mgr).
+		For(&v1beta.WireguardAccessRule{}).
+		WithValidator(&ruleWebhook{}).
+		Complete()
+	if err != nil {
+		return fmt.Errorf("rule webhook: %w", err)
+	}
+
+	err = ctrl.NewWebhookManagedBy(mgr).
+		For(&v1beta.WireguardAccessRevocation{}).
+		WithValidator(&revocationWebhook{}).
+		Complete()
+	if err != nil {
+		return fmt.Errorf("revocation webhook: %w", err)
+	}
+
+	err = ctrl.NewWebhookManagedBy(mgr).
+		For(&v1beta.WireguardClusterClient{}).
+		WithDefaulter(&clusterClientWebhook{}).
+		WithValidator(&clusterClientWebhook{}).
+		Complete()
+	if err != nil {
+		return fmt.Errorf("cluster client webhook: %w", err)
+	}
+
+	return nil
+}
+
+func invalid(kind string, name string, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(v1beta.SchemeGroupVersion.WithKind(kind).GroupKind(), name, errs)
+}
+
+type peerWebhook struct {
+	client client.Reader
+}
+
+// Default trims whitespace from keys, which sneaks in when they are pasted.
+func (w *peerWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	peer, ok := obj.(*v1beta.WireguardAccessPeer)
+	if !ok {
+		return fmt.Errorf("expected a WireguardAccessPeer, got %T", obj)
+	}
+
+	peer.Spec.PublicKey = strings.TrimSpace(peer.Spec.PublicKey)
+	peer.Spec.PreSharedKey = strings.TrimSpace(peer.Spec.PreSharedKey)
+
+	return nil
+}
+
+func (w *peerWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	peer, ok := obj.(*v1beta.WireguardAccessPeer)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardAccessPeer, got %T", obj)
+	}
+
+	return nil, w.validate(ctx, nil, peer)
+}
+
+func (w *peerWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	old, ok := oldObj.(*v1beta.WireguardAccessPeer)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardAccessPeer, got %T", oldObj)
+	}
+
+	peer, ok := newObj.(*v1beta.WireguardAccessPeer)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardAccessPeer, got %T", newObj)
+	}
+
+	return nil, w.validate(ctx, old, peer)
+}
+
+func (w *peerWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate checks the spec, and on create or when they change, that the public key is unique and not revoked and the rules exist.
+// Unchanged references are not checked again, so deleting a rule doesn't lock the peers that use it.
+func (w *peerWebhook) validate(ctx context.Context, old, peer *v1beta.WireguardAccessPeer) error {
+	path := field.NewPath("spec")
+	errs := validatePeerSpec(&peer.Spec, path)
+
+	if peer.Spec.PublicKey != "" && (old == nil || old.Spec.PublicKey != peer.Spec.PublicKey) {
+		peers := new(v1beta.WireguardAccessPeerList)
+		err := w.client.List(ctx, peers)
+		if err != nil {
+			return apierrors.NewInternalError(fmt.Errorf("error listing peers: %w", err))
+		}
+
+		for _, p := range peers.Items {
+			if p.Name != peer.Name && p.Spec.PublicKey == peer.Spec.PublicKey {
+				errs = append(errs, field.Invalid(path.Child("publicKey"), peer.Spec.PublicKey, "already used by peer "+p.Name))
+			}
+		}
+
+		rev, err := revocationFor(ctx, w.client, peer.Spec.PublicKey)
+		if err != nil {
+			return apierrors.NewInternalError(err)
+		}
+		if rev != nil {
+			errs = append(errs, field.Forbidden(path.Child("publicKey"), "revoked by "+rev.Name))
+		}
+	}
+
+	rules := new(v1beta.WireguardAccessRuleList)
+	err := w.client.List(ctx, rules)
+	if err != nil {
+		return apierrors.NewInternalError(fmt.Errorf("error listing rules: %w", err))
+	}
+
+	for i, name := range peer.Spec.AccessRules {
+		if old != nil && slices.Contains(old.Spec.AccessRules, name) {
+			continue
+		}
+
+		if !slices.ContainsFunc(rules.Items, func(r v1beta.WireguardAccessRule) bool { return r.Name == name }) {
+			errs = append(errs, field.NotFound(path.Child("accessRules").Index(i), name))
+		}
+	}
+
+	return invalid("WireguardAccessPeer", peer.Name, errs)
+}
+
+type ruleWebhook struct{}
+
+func (w *ruleWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	rule, ok := obj.(*v1beta.WireguardAccessRule)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardAccessRule, got %T", obj)
+	}
+
+	return nil, invalid("WireguardAccessRule", rule.Name, validateRuleSpec(&rule.Spec, field.NewPath("spec")))
+}
+
+func (w *ruleWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return w.ValidateCreate(ctx, newObj)
+}
+
+func (w *ruleWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+type revocationWebhook struct{}
+
+func (w *revocationWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	rev, ok := obj.(*v1beta.WireguardAccessRevocation)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardAccessRevocation, got %T", obj)
+	}
+
+	return nil, invalid("WireguardAccessRevocation", rev.Name, validateRevocationSpec(&rev.Spec, field.NewPath("spec")))
+}
+
+func (w *revocationWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return w.ValidateCreate(ctx, newObj)
+}
+
+func (w *revocationWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+type clusterClientWebhook struct{}
+
+// Default lets the cluster client generate private keys for nodes that have none,
+// they are stored in the secret the reconciler would pick anyway.
+func (w *clusterClientWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	wgc, ok := obj.(*v1beta.WireguardClusterClient)
+	if !ok {
+		return fmt.Errorf("expected a WireguardClusterClient, got %T", obj)
+	}
+
+	for i, n := range wgc.Spec.Nodes {
+		if n.PrivateKey.Value == nil && n.PrivateKey.SecretRef == nil && n.NodeName != "" {
+			wgc.Spec.Nodes[i].PrivateKey.SecretRef = &corev1.SecretReference{
+				Name: formatSecretName(n.NodeName, wgc.Name),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *clusterClientWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	wgc, ok := obj.(*v1beta.WireguardClusterClient)
+	if !ok {
+		return nil, fmt.Errorf("expected a WireguardClusterClient, got %T", obj)
+	}
+
+	return nil, invalid("WireguardClusterClient", wgc.Name, validateClusterClientSpec(&wgc.Spec, field.NewPath("spec")))
+}
+
+func (w *clusterClientWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return w.ValidateCreate(ctx, newObj)
+}
+
+func (w *clusterClientWebhook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
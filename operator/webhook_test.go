@@ -0,0 +1,119 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPeerWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	alice := testPeer("alice", mustKey(t).PublicKey(), []string{"intranet"}, "fd00:1::1")
+	rule := testRule("intranet", "fd00:2::/64")
+	stolen := mustKey(t).PublicKey().String()
+	rev := v1beta.WireguardAccessRevocation{
+		ObjectMeta: metav1.ObjectMeta{Name: "stolen"},
+		Spec:       v1beta.WireguardAccessRevocationSpec{PublicKey: stolen},
+	}
+	w := &peerWebhook{client: testClient(&alice, &rule, &rev)}
+
+	for _, tc := range []struct {
+		name string
+		spec v1beta.WireguardAccessPeerSpec
+		err  string
+	}{
+		{
+			name: "valid",
+			spec: v1beta.WireguardAccessPeerSpec{PublicKey: mustKey(t).PublicKey().String(), AccessRules: []string{"intranet"}},
+		},
+		{
+			name: "bad key",
+			spec: v1beta.WireguardAccessPeerSpec{PublicKey: "nope"},
+			err:  "spec.publicKey",
+		},
+		{
+			name: "duplicate key",
+			spec: v1beta.WireguardAccessPeerSpec{PublicKey: alice.Spec.PublicKey},
+			err:  "already used by peer alice",
+		},
+		{
+			name: "revoked key",
+			spec: v1beta.WireguardAccessPeerSpec{PublicKey: stolen},
+			err:  "revoked by stolen",
+		},
+		{
+			name: "unknown rule",
+			spec: v1beta.WireguardAccessPeerSpec{PublicKey: mustKey(t).PublicKey().String(), AccessRules: []string{"intranet", "typo"}},
+			err:  "spec.accessRules[1]: Not found",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peer := &v1beta.WireguardAccessPeer{ObjectMeta: metav1.ObjectMeta{Name: "bob"}, Spec: tc.spec}
+
+			_, err := w.ValidateCreate(ctx, peer)
+			if tc.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+
+	// references that didn't change are not checked, the rule may have been deleted since
+	old := alice.DeepCopy()
+	old.Spec.AccessRules = []string{"gone"}
+	updated := old.DeepCopy()
+	updated.Spec.Disabled = true
+	if _, err := w.ValidateUpdate(ctx, old, updated); err != nil {
+		t.Errorf("update of peer with deleted rule rejected: %v", err)
+	}
+}
+
+func TestClusterClientWebhook(t *testing.T) {
+	ctx := context.Background()
+	w := &clusterClientWebhook{}
+
+	wgc := &v1beta.WireguardClusterClient{
+		ObjectMeta: metav1.ObjectMeta{Name: "office"},
+		Spec: v1beta.WireguardClusterClientSpec{
+			Server: v1beta.WireguardClusterClientSpecServer{
+				Endpoint:  "[2001:db8::1]:51820",
+				PublicKey: mustKey(t).PublicKey().String(),
+			},
+			Routes: []string{"fd00:2::/64"},
+			Nodes: []v1beta.WireguardClusterClientNode{
+				{NodeName: "a", Address: "fd00:1::1"},
+			},
+		},
+	}
+
+	if _, err := w.ValidateCreate(ctx, wgc); err == nil {
+		t.Error("node without private key accepted")
+	}
+
+	if err := w.Default(ctx, wgc); err != nil {
+		t.Fatal(err)
+	}
+	if ref := wgc.Spec.Nodes[0].PrivateKey.SecretRef; ref == nil || ref.Name != "wgc-office-a" {
+		t.Fatalf("private key secret not defaulted: %v", ref)
+	}
+	if _, err := w.ValidateCreate(ctx, wgc); err != nil {
+		t.Errorf("defaulted cluster client rejected: %v", err)
+	}
+
+	wgc.Spec.Server.Endpoint = "vpn.example.com"
+	wgc.Spec.Routes = append(wgc.Spec.Routes, "10.0.0.1")
+	wgc.Spec.Nodes = append(wgc.Spec.Nodes, wgc.Spec.Nodes[0])
+
+	_, err := w.ValidateCreate(ctx, wgc)
+	for _, want := range []string{"spec.server.endpoint", "spec.routes[1]", "spec.nodes[1].nodeName"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error for %s, got %v", want, err)
+		}
+	}
+}
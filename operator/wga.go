@@ -1,14 +1,15 @@
 package operator
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/big"
 	"math/rand/v2"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,15 +18,19 @@ import (
 	"github.com/apparentlymart/go-cidr/cidr"
 	"github.com/go-logr/logr"
 	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
-	"github.com/vishvananda/netlink"
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -37,6 +42,9 @@ import (
 
 const (
 	DEVICENAME = "wga"
+
+	// PeerReconcileWorkers is how many peers are reconciled at once.
+	PeerReconcileWorkers = 8
 )
 
 // WGConfig is readonly after `wgInit` is called.
@@ -49,8 +57,8 @@ func init() {
 	v1beta.SchemeBuilder.AddToScheme(scheme.Scheme)
 }
 
-func RunWGA(ctx context.Context, config *rest.Config, serviceNets []net.IPNet, peerNets []net.IPNet, dnsServers []string, serverAddr string) {
-	mgr, err := manager.New(config, manager.Options{})
+func RunWGA(ctx context.Context, config *rest.Config, dp Dataplane, dryRun bool, serviceNets []net.IPNet, peerNets []net.IPNet, dnsServers []string, serverAddr string, policy PeerPolicy) {
+	mgr, err := manager.New(config, managerOptions(dryRun))
 	if err != nil {
 		slog.Error("unable to create new manager", "err", err)
 		os.Exit(1)
@@ -59,15 +67,32 @@ func RunWGA(ctx context.Context, config *rest.Config, serviceNets []net.IPNet, p
 	log.SetLogger(logr.FromSlogHandler(slog.With("component", "wga-controller").Handler()))
 
 	registerLoadBalancerReconciler(mgr, serviceNets, slog.Default())
-	registerPeerReconciler(mgr, serviceNets, peerNets, dnsServers, serverAddr, slog.Default())
+	syncer := newSyncer(mgr.GetClient(), dp, slog.Default())
+	registerPeerReconciler(mgr, dp, syncer, serviceNets, peerNets, dnsServers, serverAddr, policy, slog.Default())
+	registerStatusWriter(mgr, syncer, dp, policy, slog.Default())
+
+	if webhookEnabled() {
+		if err := registerWebhooks(mgr); err != nil {
+			slog.Error("unable to set up webhooks", "err", err)
+			os.Exit(1)
+		}
+	}
 
-	if err = mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
-		slog.Error("unable to set up health check", "err", err)
-		os.Exit(1)
+	readyChecks := map[string]healthz.Checker{
+		"device":     checkWGADevice(dp),
+		"nft":        checkNFT(dp),
+		"forwarding": checkForwarding(dp),
+		"sync":       checkLastSync,
+	}
+	if webhookEnabled() {
+		readyChecks["webhook"] = mgr.GetWebhookServer().StartedChecker()
 	}
 
-	if err = mgr.AddReadyzCheck("check", healthz.Ping); err != nil {
-		slog.Error("unable to set up ready check", "err", err)
+	err = addHealthChecks(mgr, map[string]healthz.Checker{
+		"device": checkWGADevice(dp),
+	}, readyChecks)
+	if err != nil {
+		slog.Error("unable to set up health checks", "err", err)
 		os.Exit(1)
 	}
 
@@ -94,27 +119,62 @@ var peerPredicate = &predicate.TypedFuncs[client.Object]{
 
 func registerPeerReconciler(
 	mgr manager.Manager,
+	dp Dataplane,
+	syncer *Syncer,
 	servicesNets []net.IPNet,
 	clientsNets []net.IPNet,
 	dnsServers []string,
 	serverAddr string,
+	policy PeerPolicy,
 	log *slog.Logger,
 ) {
-	epInit(clientsNets)
+	epInit(dp, clientsNets)
+
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1beta.WireguardAccessPeer{}, peerSecretIndex, indexPeerSecret)
+	if err != nil {
+		log.Error("Error indexing peer secrets", "error", err)
+		os.Exit(1)
+	}
+
+	// deleted objects are never reconciled, their removal only needs a sync
+	syncOnDelete := handler.Funcs{
+		DeleteFunc: func(context.Context, event.DeleteEvent, workqueue.RateLimitingInterface) {
+			syncer.Request()
+		},
+	}
 
-	err := ctrl.NewControllerManagedBy(mgr).
+	err = ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta.WireguardAccessPeer{}).
+		// reconciles waiting for the same sync are served together
+		WithOptions(controller.Options{MaxConcurrentReconciles: PeerReconcileWorkers}).
 		WithEventFilter(peerPredicate).
 		Owns(&v1beta.WireguardAccessPeer{}, builder.WithPredicates(peerPredicate)).
 		Watches(&v1beta.WireguardAccessPeer{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
 			return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(o)}}
 		}), builder.WithPredicates(peerPredicate)).
+		Watches(&v1beta.WireguardAccessPeer{}, syncOnDelete).
+		// peers report missing rules in their status
+		Watches(&v1beta.WireguardAccessRule{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+			return peersWithRule(ctx, mgr.GetClient(), o.GetName())
+		}), builder.WithPredicates(peerPredicate)).
+		// revoking a key removes its peers
+		Watches(&v1beta.WireguardAccessRevocation{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+			return peersWithKey(ctx, mgr.GetClient(), o.(*v1beta.WireguardAccessRevocation).Spec.PublicKey)
+		}), builder.WithPredicates(peerPredicate)).
+		// rotated preshared keys reach the device through their secret, only labelled secrets are watched
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
+			return peersWithSecret(ctx, mgr.GetClient(), o)
+		}), builder.WithPredicates(peerPredicate)).
 		Complete(reconcile.AsReconciler(mgr.GetClient(), &PeerReconciler{
 			serverAddr:   serverAddr,
 			clientsNets:  clientsNets,
 			servicesNets: servicesNets,
 			dnsServers:   dnsServers,
+			policy:       policy,
 			client:       mgr.GetClient(),
+			recorder:     mgr.GetEventRecorderFor("wga-endpoint"),
+			dp:           dp,
+			syncer:       syncer,
 			log:          log.With("component", "peer-reconciler"),
 		}))
 	if err != nil {
@@ -122,6 +182,12 @@ func registerPeerReconciler(
 		os.Exit(1)
 	}
 
+	err = mgr.Add(syncer)
+	if err != nil {
+		log.Error("Error creating sync worker", "error", err)
+		os.Exit(1)
+	}
+
 	err = ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta.WireguardAccessRule{}).
 		WithEventFilter(peerPredicate).
@@ -129,9 +195,12 @@ func registerPeerReconciler(
 		Watches(&v1beta.WireguardAccessRule{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, o client.Object) []reconcile.Request {
 			return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(o)}}
 		}), builder.WithPredicates(peerPredicate)).
+		Watches(&v1beta.WireguardAccessRule{}, syncOnDelete).
 		Complete(reconcile.AsReconciler(mgr.GetClient(), &RulesReconciler{
-			client: mgr.GetClient(),
-			log:    log.With("component", "rules-reconciler"),
+			client:   mgr.GetClient(),
+			recorder: mgr.GetEventRecorderFor("wga-endpoint"),
+			syncer:   syncer,
+			log:      log.With("component", "rules-reconciler"),
 		}))
 	if err != nil {
 		log.Error("Error creating peer reconciler", "error", err)
@@ -140,13 +209,116 @@ func registerPeerReconciler(
 }
 
 type RulesReconciler struct {
-	client client.Client
-	log    *slog.Logger
+	client   client.Client
+	recorder record.EventRecorder
+	syncer   *Syncer
+	log      *slog.Logger
 }
 
 func (r *RulesReconciler) Reconcile(ctx context.Context, rule *v1beta.WireguardAccessRule) (ctrl.Result, error) {
 	r.log.Info("reconciling rule", "rule", rule.Name)
-	return ctrl.Result{}, WGASync(r.client, r.log)
+
+	if rule.Status == nil {
+		rule.Status = &v1beta.WireguardAccessRuleStatus{}
+	}
+	old := rule.Status.DeepCopy()
+
+	// status writes come back here, only spec changes need a sync
+	var syncErr error
+	if rule.Status.ObservedGeneration != rule.Generation {
+		syncErr = r.syncer.Sync(ctx)
+	}
+
+	invalid := validateRuleSpec(&rule.Spec, field.NewPath("spec"))
+	if len(invalid) > 0 {
+		if setCondition(&rule.Status.Conditions, rule.Generation, v1beta.ConditionInvalidSpec, true, "ValidationFailed", invalid.ToAggregate().Error()) {
+			r.recorder.Event(rule, corev1.EventTypeWarning, "InvalidSpec", invalid.ToAggregate().Error())
+		}
+		setCondition(&rule.Status.Conditions, rule.Generation, v1beta.ConditionReady, false, "InvalidSpec", "invalid destinations are not applied")
+	} else {
+		setCondition(&rule.Status.Conditions, rule.Generation, v1beta.ConditionInvalidSpec, false, "Valid", "")
+	}
+
+	switch {
+	case syncErr != nil:
+		if setCondition(&rule.Status.Conditions, rule.Generation, v1beta.ConditionReady, false, syncFailedReason(syncErr), syncErr.Error()) {
+			r.recorder.Event(rule, corev1.EventTypeWarning, syncFailedReason(syncErr), syncErr.Error())
+		}
+	case len(invalid) == 0:
+		setCondition(&rule.Status.Conditions, rule.Generation, v1beta.ConditionReady, true, "Ready", "")
+	}
+
+	// a failed sync is tried again on the next reconcile
+	if syncErr == nil {
+		rule.Status.ObservedGeneration = rule.Generation
+	}
+
+	if !equality.Semantic.DeepEqual(old, rule.Status) {
+		err := r.client.Status().Update(ctx, rule)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if syncErr != nil {
+		after := syncRequeueAfter(syncErr)
+		if after == 0 {
+			return ctrl.Result{}, syncErr
+		}
+
+		return ctrl.Result{RequeueAfter: after}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// peersWithRule returns requests for all peers referencing the rule.
+func peersWithRule(ctx context.Context, c client.Client, rule string) []reconcile.Request {
+	peers := new(v1beta.WireguardAccessPeerList)
+	err := c.List(ctx, peers)
+	if err != nil {
+		slog.Error("Error listing peers", "error", err)
+		return nil
+	}
+
+	reqs := []reconcile.Request{}
+	for _, p := range peers.Items {
+		if slices.Contains(p.Spec.AccessRules, rule) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&p)})
+		}
+	}
+
+	return reqs
+}
+
+// peerSecretIndex indexes peers by the namespace/name of their preshared key secret.
+const peerSecretIndex = "spec.preSharedKeySecretRef"
+
+func indexPeerSecret(o client.Object) []string {
+	ref := o.(*v1beta.WireguardAccessPeer).Spec.PreSharedKeySecretRef
+	if ref == nil {
+		return nil
+	}
+
+	r := withNamespace(*ref, getK8sNamespace())
+	return []string{r.Namespace + "/" + r.Name}
+}
+
+// peersWithSecret returns requests for all peers keeping their preshared key in the secret.
+func peersWithSecret(ctx context.Context, c client.Reader, secret client.Object) []reconcile.Request {
+	peers := new(v1beta.WireguardAccessPeerList)
+	err := c.List(ctx, peers, client.MatchingFields{peerSecretIndex: secret.GetNamespace() + "/" + secret.GetName()})
+	if err != nil {
+		slog.Error("Error listing peers", "error", err)
+		return nil
+	}
+
+	reqs := []reconcile.Request{}
+	for _, p := range peers.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&p)})
+	}
+
+	return reqs
 }
 
 type PeerReconciler struct {
@@ -154,28 +326,221 @@ type PeerReconciler struct {
 	clientsNets  []net.IPNet
 	servicesNets []net.IPNet
 	dnsServers   []string
+	policy       PeerPolicy
 	client       client.Client
+	recorder     record.EventRecorder
+	dp           Dataplane
+	syncer       *Syncer
 	log          *slog.Logger
+
+	// specs last synced to the dataplane, by peer name
+	syncedLock sync.Mutex
+	synced     map[string]v1beta.WireguardAccessPeerSpec
+}
+
+// specChanged records the peer's spec and reports whether it differs from the last one seen.
+// The first time a peer is seen does not count, the initial sync already covers it.
+func (r *PeerReconciler) specChanged(peer *v1beta.WireguardAccessPeer) bool {
+	r.syncedLock.Lock()
+	defer r.syncedLock.Unlock()
+
+	if r.synced == nil {
+		r.synced = map[string]v1beta.WireguardAccessPeerSpec{}
+	}
+
+	old, ok := r.synced[peer.Name]
+	r.synced[peer.Name] = *peer.Spec.DeepCopy()
+
+	return ok && !equality.Semantic.DeepEqual(old, peer.Spec)
+}
+
+// forgetSpec drops the recorded spec of a deleted peer.
+func (r *PeerReconciler) forgetSpec(name string) {
+	r.syncedLock.Lock()
+	defer r.syncedLock.Unlock()
+
+	delete(r.synced, name)
 }
 
 func (r *PeerReconciler) Reconcile(ctx context.Context, peer *v1beta.WireguardAccessPeer) (ctrl.Result, error) {
+	now := time.Now()
 
-	if peer.Status != nil && len(peer.Status.Addresses) != 0 {
-		return ctrl.Result{}, nil
+	if !peer.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, peer)
 	}
 
-	if peer.Status != nil && peer.Status.Address != "" {
-		r.log.Info("migrating peer status Address -> Addresses", "peer", peer.Name)
+	err := r.addFinalizer(ctx, peer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 
-		peer.Status.Addresses = []string{peer.Status.Address}
+	// the spec update brings the peer back with its key
+	if peer.Spec.PublicKey == "" {
+		return ctrl.Result{}, r.generateKey(ctx, peer)
+	}
+
+	// invalid keys stay inline to be reported below
+	if _, err := wgtypes.ParseKey(peer.Spec.PreSharedKey); peer.Spec.PreSharedKey != "" && err == nil {
+		return ctrl.Result{}, r.migratePreSharedKey(ctx, peer)
+	}
 
-		err := r.client.Update(ctx, peer)
+	if peer.Status == nil || len(peer.Status.Addresses) == 0 {
+		err := r.allocate(ctx, peer)
 		if err != nil {
-			slog.Error(err.Error(), "peer", peer.Name)
 			return ctrl.Result{}, err
 		}
 	}
 
+	err = r.migrateStatusPreSharedKeys(ctx, peer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	old := peer.Status.DeepCopy()
+
+	// eg. disabled or enabled
+	changed := r.specChanged(peer)
+	if setExpiredCondition(peer, now) {
+		r.log.Info("peer expiry changed", "peer", peer.Name, "expired", peerExpired(peer, now))
+		changed = true
+	}
+
+	rotated, err := r.rotatePreSharedKey(ctx, peer, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	changed = changed || rotated
+
+	invalid := validatePeerSpec(&peer.Spec, field.NewPath("spec"))
+	if len(invalid) > 0 {
+		if setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionInvalidSpec, true, "ValidationFailed", invalid.ToAggregate().Error()) {
+			r.recorder.Event(peer, corev1.EventTypeWarning, "InvalidSpec", invalid.ToAggregate().Error())
+		}
+	} else {
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionInvalidSpec, false, "Valid", "")
+	}
+
+	missing, err := r.missingRules(ctx, peer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	psk, pskErr := peerPreSharedKey(ctx, r.client, peer, getK8sNamespace())
+
+	rev, err := revocationFor(ctx, r.client, peer.Spec.PublicKey)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if setRevokedCondition(peer, rev) && rev != nil {
+		r.log.Warn("peer uses a revoked key", "peer", peer.Name, "revocation", rev.Name)
+		r.recorder.Eventf(peer, corev1.EventTypeWarning, "Revoked", "public key revoked by %s, removing peer from the endpoint", rev.Name)
+	}
+
+	// the peer should be on the device exactly when it is valid and active
+	want := len(invalid) == 0 && rev == nil && pskErr == nil && peerInactive(peer, now) == ""
+	present, devicePSK, err := r.onDevice(peer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// eg. a rotated key that reached the cache after the rotation synced
+	stale := present && want && devicePSK != psk
+
+	var syncErr error
+	if changed || stale || present != want {
+		syncErr = r.syncer.Sync(ctx)
+
+		present, _, err = r.onDevice(peer)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	switch {
+	case syncErr != nil:
+		if setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionDataplaneSynced, false, syncFailedReason(syncErr), syncErr.Error()) {
+			r.recorder.Event(peer, corev1.EventTypeWarning, syncFailedReason(syncErr), syncErr.Error())
+		}
+	case present == want:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionDataplaneSynced, true, "Synced", "")
+	default:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionDataplaneSynced, false, "Pending", "waiting for the endpoint to apply the peer")
+	}
+
+	switch {
+	case len(invalid) > 0:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, false, "InvalidSpec", invalid.ToAggregate().Error())
+	case rev != nil:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, false, "Revoked", "public key revoked by "+rev.Name)
+	case pskErr != nil:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, false, "MissingPreSharedKey", pskErr.Error())
+	case peerInactive(peer, now) != "":
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, false, peerInactive(peer, now), "peer is not configured on the endpoint")
+	case len(missing) > 0:
+		message := "access rules not found: " + strings.Join(missing, ", ")
+		if setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, false, "MissingAccessRule", message) {
+			r.recorder.Event(peer, corev1.EventTypeWarning, "MissingAccessRule", message)
+		}
+	case syncErr != nil:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, false, syncFailedReason(syncErr), syncErr.Error())
+	case present != want:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, false, "Pending", "waiting for the endpoint to apply the peer")
+	default:
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionReady, true, "Ready", "")
+	}
+
+	peer.Status.ObservedGeneration = peer.Generation
+
+	if !equality.Semantic.DeepEqual(old, peer.Status) {
+		err = r.client.Status().Update(ctx, peer)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err = r.renderConfig(ctx, peer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	res, err := r.reconcileExpiry(ctx, peer, now)
+	if err != nil {
+		return res, err
+	}
+
+	next, err := r.nextPSKRotation(ctx, peer)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !next.IsZero() && (res.RequeueAfter == 0 || next.Sub(now) < res.RequeueAfter) {
+		res.RequeueAfter = next.Sub(now)
+	}
+
+	if syncErr != nil {
+		after := syncRequeueAfter(syncErr)
+		if after == 0 {
+			return ctrl.Result{}, syncErr
+		}
+
+		if res.RequeueAfter == 0 || after < res.RequeueAfter {
+			res.RequeueAfter = after
+		}
+	}
+
+	return res, nil
+}
+
+// allocate assigns addresses to a new peer and writes its status.
+func (r *PeerReconciler) allocate(ctx context.Context, peer *v1beta.WireguardAccessPeer) error {
+	if peer.Status != nil && peer.Status.Address != "" {
+		r.log.Info("migrating peer status Address -> Addresses", "peer", peer.Name)
+
+		peer.Status.Addresses = []string{peer.Status.Address}
+		setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionAddressAllocated, true, "Allocated", "")
+
+		return r.client.Status().Update(ctx, peer)
+	}
+
 	r.log.Info("setting peer status", "peer", peer.Name)
 
 	clientNetsV4 := []net.IPNet{}
@@ -196,7 +561,7 @@ func (r *PeerReconciler) Reconcile(ctx context.Context, peer *v1beta.WireguardAc
 		sip, err := cidr.HostBig(&cnet, generateIndex(time.Now(), maskBits(cnet)))
 		if err != nil {
 			r.log.Error(err.Error(), "peer", peer.Name)
-			return ctrl.Result{}, err
+			return err
 		}
 		addrs = append(addrs, sip.String())
 	}
@@ -208,13 +573,33 @@ func (r *PeerReconciler) Reconcile(ctx context.Context, peer *v1beta.WireguardAc
 		sip, err := cidr.HostBig(&cnet, generateIndex(time.Now(), maskBits(cnet)))
 		if err != nil {
 			r.log.Error(err.Error(), "peer", peer.Name)
-			return ctrl.Result{}, err
+			return err
 		}
 		addrs = append(addrs, sip.String())
 	}
 
 	*/
 
+	// the index is random, another peer may already have the address
+	peers := new(v1beta.WireguardAccessPeerList)
+	err := r.client.List(ctx, peers)
+	if err != nil {
+		return fmt.Errorf("error listing peers: %w", err)
+	}
+
+	for _, p := range peers.Items {
+		if p.Name == peer.Name || p.Status == nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if slices.Contains(p.Status.Addresses, addr) || p.Status.Address == addr {
+				r.recorder.Eventf(peer, corev1.EventTypeWarning, "AddressConflict", "address %s is already allocated to peer %s, retrying", addr, p.Name)
+				return fmt.Errorf("address %s is already allocated to peer %s", addr, p.Name)
+			}
+		}
+	}
+
 	peer.Status = &v1beta.WireguardAccessPeerStatus{
 		LastUpdated: metav1.Now(),
 		Address:     addrs[0],
@@ -229,13 +614,55 @@ func (r *PeerReconciler) Reconcile(ctx context.Context, peer *v1beta.WireguardAc
 		},
 	}
 
-	err := r.client.Update(ctx, peer)
+	setCondition(&peer.Status.Conditions, peer.Generation, v1beta.ConditionAddressAllocated, true, "Allocated", "")
+
+	err = r.client.Status().Update(ctx, peer)
 	if err != nil {
-		slog.Error(err.Error(), "peer", peer.Name)
-		return ctrl.Result{}, err
+		return err
 	}
 
-	return ctrl.Result{}, WGASync(r.client, r.log)
+	r.recorder.Eventf(peer, corev1.EventTypeNormal, "AddressAllocated", "allocated %s", strings.Join(addrs, ", "))
+
+	return nil
+}
+
+// onDevice reports whether the peer is configured on the wga device, and with which preshared key.
+func (r *PeerReconciler) onDevice(peer *v1beta.WireguardAccessPeer) (bool, wgtypes.Key, error) {
+	device, err := r.dp.Device(DEVICENAME)
+	if err != nil {
+		return false, wgtypes.Key{}, fmt.Errorf("wg.Device(%s): %w", DEVICENAME, err)
+	}
+
+	for _, p := range device.Peers {
+		if p.PublicKey.String() == peer.Spec.PublicKey {
+			return true, p.PresharedKey, nil
+		}
+	}
+
+	return false, wgtypes.Key{}, nil
+}
+
+// missingRules returns the access rules referenced by the peer that don't exist.
+func (r *PeerReconciler) missingRules(ctx context.Context, peer *v1beta.WireguardAccessPeer) ([]string, error) {
+	rules := new(v1beta.WireguardAccessRuleList)
+	err := r.client.List(ctx, rules)
+	if err != nil {
+		return nil, fmt.Errorf("error listing rules: %w", err)
+	}
+
+	exists := make(map[string]bool, len(rules.Items))
+	for _, rule := range rules.Items {
+		exists[rule.Name] = true
+	}
+
+	missing := []string{}
+	for _, name := range peer.Spec.AccessRules {
+		if !exists[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing, nil
 }
 
 func netsAsStrings(nets []net.IPNet) []string {
@@ -249,6 +676,8 @@ func netsAsStrings(nets []net.IPNet) []string {
 type Config struct {
 	Rules []v1beta.WireguardAccessRule
 	Peers []v1beta.WireguardAccessPeer
+	// Revoked maps revoked public keys to the revocation that lists them.
+	Revoked map[string]string
 }
 
 func Fetch(ctx context.Context, client client.Client) (*Config, error) {
@@ -259,110 +688,123 @@ func Fetch(ctx context.Context, client client.Client) (*Config, error) {
 		return nil, fmt.Errorf("error listing wga: %w", err)
 	}
 
+	// deleted peers are kept around by their finalizer until they are off the device
+	wgap.Items = slices.DeleteFunc(wgap.Items, func(p v1beta.WireguardAccessPeer) bool {
+		return !p.DeletionTimestamp.IsZero()
+	})
+
 	wgar := new(v1beta.WireguardAccessRuleList)
 	err = client.List(ctx, wgar)
 	if err != nil {
 		return nil, fmt.Errorf("error listing wga: %w", err)
 	}
 
+	revoked, err := revokedKeys(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		Rules: wgar.Items,
-		Peers: wgap.Items,
+		Rules:   wgar.Items,
+		Peers:   resolvePreSharedKeys(ctx, client, wgap.Items),
+		Revoked: revoked,
 	}, nil
 }
 
-func WGASync(client client.Client, log *slog.Logger) error {
+// WGASync applies all peers and rules to the endpoint.
+// Failed stages are returned as *SyncError, joined if several failed.
+func WGASync(client client.Client, dp Dataplane, log *slog.Logger) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	start := time.Now()
+	defer func() {
+		syncDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	fail := func(stage string, err error) error {
+		err = &SyncError{Stage: stage, Err: err}
+		log.Error("Error syncing", "stage", stage, "kind", syncErrorKind(err), "error", err)
+		syncErrors.WithLabelValues(stage, syncErrorKind(err)).Inc()
+		return err
+	}
+
 	cfg, err := Fetch(ctx, client)
 	if err != nil {
-		log.Error("Error fetching CRDs", "error", err)
-		return nil
+		err = fail(SyncStageFetch, err)
+		recordSync(err)
+		return err
 	}
 
+	var wgErr, nftErr error
+
 	log.Debug("syncing wg")
-	err = wgaSync(log, cfg)
-	if err != nil {
-		log.Error("Error syncing CRDs", "error", err)
+	if err := wgaSync(log, dp, cfg); err != nil {
+		wgErr = fail(SyncStageWG, err)
 	}
 	log.Debug("syncing wg done")
 
 	log.Debug("syncing nft")
-	nftSync(ctx, log, cfg, "wga")
+	if err := nftSync(ctx, log, dp, cfg, DEVICENAME); err != nil {
+		nftErr = fail(SyncStageNFT, err)
+	}
 	log.Debug("syncing nft done")
 
 	log.Debug("syncing sysctl")
-	sysctl(ctx, log)
+	sysctl(ctx, log, dp)
 	log.Debug("syncing sysctl done")
 
-	return nil
+	err = errors.Join(wgErr, nftErr)
+	recordSync(err)
+
+	return err
 }
 
-func epInit(clientCIDRs []net.IPNet) {
+func epInit(dp Dataplane, clientCIDRs []net.IPNet) {
 	WGInitOnce.Do(func() {
-		if err := wgaInit(clientCIDRs); err != nil {
+		sk, err := readKey()
+		if err != nil {
+			panic(fmt.Errorf("cannot read wg private key: %w", err))
+		}
+
+		if err := wgaInit(dp, sk, clientCIDRs); err != nil {
 			panic(err)
 		}
 	})
 
-	NFTInitOnce.Do(nftInit)
+	NFTInitOnce.Do(func() { nftInit(dp) })
 }
 
-func wgaInit(clientCIDRs []net.IPNet) error {
+func wgaInit(dp Dataplane, sk wgtypes.Key, clientCIDRs []net.IPNet) error {
 	slog.Info("create wg", "interface", DEVICENAME)
 
 	// delete old link
-	link, _ := netlink.LinkByName(DEVICENAME)
-	if link != nil {
-		slog.Info("delete old wg", "interface", DEVICENAME)
-		netlink.LinkDel(link)
-	}
-
-	wirelink := &netlink.GenericLink{
-		LinkAttrs: netlink.LinkAttrs{
-			Name: DEVICENAME,
-		},
-		LinkType: "wireguard",
-	}
-	err := netlink.LinkAdd(wirelink)
+	err := dp.DelLink(DEVICENAME)
 	if err != nil {
-		return fmt.Errorf("cannot create wg interface: %w", err)
+		return fmt.Errorf("cannot delete old wg interface: %w", err)
 	}
-	link, _ = netlink.LinkByName(DEVICENAME)
 
-	// bring up wg
-	wg, err := wgctrl.New()
+	err = dp.AddLink(DEVICENAME)
 	if err != nil {
-		return fmt.Errorf("wgctrl.New: %w", err)
-	}
-	defer wg.Close()
-
-	sk, err := readKey()
-	if err != nil {
-		return fmt.Errorf("cannot read wg private key: %w", err)
+		return fmt.Errorf("cannot create wg interface: %w", err)
 	}
 
 	WGConfig.PrivateKey = &sk
 	port := 51820
 	WGConfig.ListenPort = &port
 
-	err = wg.ConfigureDevice(DEVICENAME, WGConfig)
+	err = dp.ConfigureDevice(DEVICENAME, WGConfig)
 	if err != nil {
 		return fmt.Errorf("wgctrl.ConfigureDevice: %w", err)
 	}
 
-	err = netlink.LinkSetUp(link)
+	err = dp.LinkUp(DEVICENAME)
 	if err != nil {
 		return fmt.Errorf("link up: %w", err)
 	}
 
 	for _, clientCIDR := range clientCIDRs {
-		err = netlink.RouteAdd(&netlink.Route{
-			LinkIndex: link.Attrs().Index,
-			Dst:       &clientCIDR,
-		})
-
+		err = dp.RouteReplace(DEVICENAME, clientCIDR)
 		if err != nil {
 			return fmt.Errorf("cannot add route: %w", err)
 		}
@@ -371,19 +813,30 @@ func wgaInit(clientCIDRs []net.IPNet) error {
 	return nil
 }
 
-func wgaSync(log *slog.Logger, config *Config) error {
-	shouldPeers := make(map[string]wgtypes.PeerConfig, 0)
+func wgaSync(log *slog.Logger, dp Dataplane, config *Config) error {
+	shouldPeers := make(map[wgtypes.Key]desiredPeer, 0)
+	now := time.Now()
 	log.Debug("syncing peers")
 	for _, peer := range config.Peers {
 		if peer.Status == nil {
 			continue
 		}
 
+		if reason := peerInactive(&peer, now); reason != "" {
+			log.Debug("skipping inactive peer", "peer", peer.Name, "reason", reason)
+			continue
+		}
+
+		if revocation, ok := config.Revoked[peer.Spec.PublicKey]; ok {
+			log.Warn("refusing peer with revoked key", "peer", peer.Name, "revocation", revocation)
+			continue
+		}
+
 		if len(peer.Status.Addresses) == 0 {
 			peer.Status.Addresses = []string{peer.Status.Address}
 		}
 
-		log.Info("syncing peer", "peer", peer.Name, "address", peer.Status.Addresses)
+		log.Debug("syncing peer", "peer", peer.Name, "address", peer.Status.Addresses)
 
 		var allowedIPs []net.IPNet
 		for _, addr := range peer.Status.Addresses {
@@ -419,6 +872,11 @@ func wgaSync(log *slog.Logger, config *Config) error {
 			}
 		}
 
+		// the key is still being generated
+		if peer.Spec.PublicKey == "" {
+			continue
+		}
+
 		pub, err := wgtypes.ParseKey(peer.Spec.PublicKey)
 		if err != nil {
 			log.Error(err.Error(), "publicKey", peer.Spec.PublicKey, "peer", peer.Name)
@@ -434,89 +892,28 @@ func wgaSync(log *slog.Logger, config *Config) error {
 			AllowedIPs:                  allowedIPs,
 		}
 
-		shouldPeers[pub.String()] = pc
-	}
-
-	log.Debug("creating wgctrl client")
-	wg, err := wgctrl.New()
-	if err != nil {
-		return fmt.Errorf("wgctrl.New: %w", err)
+		shouldPeers[pub] = desiredPeer{
+			Name:   peer.Name,
+			Config: pc,
+		}
 	}
-	defer wg.Close()
 
 	log.Debug("getting existing device")
-	existing_device, err := wg.Device(DEVICENAME)
+	existing_device, err := dp.Device(DEVICENAME)
 	if err != nil {
 		return fmt.Errorf("wg.Device(%s): %w", DEVICENAME, err)
 	}
+	// peers about to be removed take their handshake with them
+	recordHandshakes(existing_device.Peers)
 
-	havePeers := make(map[string]*wgtypes.Peer, 0)
-	for _, v := range existing_device.Peers {
-		vclone := v
-		havePeers[v.PublicKey.String()] = &vclone
-	}
-
-	nuconfig := wgtypes.Config{
-		ReplacePeers: false,
-	}
-
-	log.Debug("comparing existing and desired peers")
-	for k, old := range havePeers {
-		if nu, ok := shouldPeers[k]; ok {
-			changed := false
-
-			if nu.PresharedKey != nil && *nu.PresharedKey != old.PresharedKey {
-				log.Info("# psk changed ", "peer", k)
-				changed = true
-			}
-			if len(nu.AllowedIPs) != len(old.AllowedIPs) {
-				log.Info("# allowedips changed", "peer", k, "from", len(old.AllowedIPs), "to", len(nu.AllowedIPs))
-				changed = true
-			} else {
-				for i := range nu.AllowedIPs {
-					if !nu.AllowedIPs[i].IP.Equal(old.AllowedIPs[i].IP) {
-						log.Info("# allowedips changed ", "peer", k, "ip", i, "from", old.AllowedIPs[i].IP, "to", nu.AllowedIPs[i].IP)
-						changed = true
-					}
-					if !bytes.Equal(nu.AllowedIPs[i].Mask, old.AllowedIPs[i].Mask) {
-						log.Info("# allowedips changed ", "peer", k, "ip", i, "from", old.AllowedIPs[i].Mask, "to", nu.AllowedIPs[i].Mask)
-						changed = true
-					}
-				}
-			}
-
-			if !changed {
-				// log.Println("# unchanged ")
-				delete(shouldPeers, k)
-				continue
-			}
-
-			nu.UpdateOnly = true
-			nu.ReplaceAllowedIPs = true
-			nuconfig.Peers = append(nuconfig.Peers, nu)
-
-			log.Info("# update ")
-			delete(shouldPeers, k)
-
-		} else {
-
-			// remove peers that are no longer in the new config
-			nuconfig.Peers = append(nuconfig.Peers, wgtypes.PeerConfig{
-				Remove:    true,
-				PublicKey: old.PublicKey,
-			})
-			log.Info("# remove ", "peer", k)
-		}
-	}
-
-	// add the rest that is not yet there
-	for k, v := range shouldPeers {
-		log.Info("# add", "pk", k)
-		nuconfig.Peers = append(nuconfig.Peers, v)
+	plan := planPeers(shouldPeers, existing_device.Peers)
+	plan.Log(log)
+	if plan.Empty() {
+		return nil
 	}
 
 	log.Debug("configuring device")
-	err = wg.ConfigureDevice(DEVICENAME, nuconfig)
+	err = dp.ConfigureDevice(DEVICENAME, plan.Config)
 	if err != nil {
 		return fmt.Errorf("wg.ConfigureDevice: %w", err)
 	}
@@ -1,8 +1,28 @@
 package operator
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func Fuzz_generateIndex(t *testing.F) {
@@ -17,3 +37,735 @@ func Fuzz_generateIndex(t *testing.F) {
 		}
 	})
 }
+
+var testLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func mustKey(t testing.TB) wgtypes.Key {
+	t.Helper()
+
+	k, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func mustCIDR(t testing.TB, s string) net.IPNet {
+	t.Helper()
+
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *n
+}
+
+func testPeer(name string, pub wgtypes.Key, rules []string, addrs ...string) v1beta.WireguardAccessPeer {
+	return v1beta.WireguardAccessPeer{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1beta.WireguardAccessPeerSpec{
+			PublicKey:   pub.String(),
+			AccessRules: rules,
+		},
+		Status: &v1beta.WireguardAccessPeerStatus{
+			Address:   addrs[0],
+			Addresses: addrs,
+			DNS:       []string{"fd00::53"},
+		},
+	}
+}
+
+func testRule(name string, destinations ...string) v1beta.WireguardAccessRule {
+	return v1beta.WireguardAccessRule{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1beta.WireguardAccessRuleSpec{
+			Destinations: destinations,
+		},
+	}
+}
+
+// testEndpoint returns a dataplane with an initialized endpoint device.
+func testEndpoint(t *testing.T) *MemoryDataplane {
+	t.Helper()
+
+	dp := NewMemoryDataplane(nil)
+	err := wgaInit(dp, mustKey(t), []net.IPNet{mustCIDR(t, "fd00:1::/64")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp.Reset()
+
+	return dp
+}
+
+// testClient returns a fake client that, like the api server, keeps status writes separate.
+func testClient(objs ...client.Object) client.WithWatch {
+	return fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&v1beta.WireguardAccessPeer{}, &v1beta.WireguardAccessRule{}, &v1beta.WireguardClusterClient{}).
+		WithIndex(&v1beta.WireguardAccessPeer{}, peerSecretIndex, indexPeerSecret).
+		Build()
+}
+
+// testSyncer runs a sync worker without debounce for the duration of the test.
+func testSyncer(t *testing.T, c client.Client, dp Dataplane) *Syncer {
+	t.Helper()
+
+	s := newSyncer(c, dp, testLog)
+	s.debounce = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Start(ctx)
+
+	return s
+}
+
+func devicePeer(t *testing.T, dp Dataplane, pub wgtypes.Key) *wgtypes.Peer {
+	t.Helper()
+
+	dev, err := dp.Device(DEVICENAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range dev.Peers {
+		if p.PublicKey == pub {
+			return &p
+		}
+	}
+	return nil
+}
+
+func TestWGAInit(t *testing.T) {
+	dp := NewMemoryDataplane(nil)
+	sk := mustKey(t)
+
+	err := wgaInit(dp, sk, []net.IPNet{mustCIDR(t, "fd00:1::/64")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := dp.Device(DEVICENAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.PublicKey != sk.PublicKey() || dev.ListenPort != 51820 {
+		t.Errorf("unexpected device %s:%d", dev.PublicKey, dev.ListenPort)
+	}
+
+	if !dp.IsUp(DEVICENAME) {
+		t.Error("device is not up")
+	}
+
+	routes, _ := dp.Routes(DEVICENAME)
+	if len(routes) != 1 || routes[0].String() != "fd00:1::/64" {
+		t.Errorf("unexpected routes %v", routes)
+	}
+
+	// a restart replaces the device
+	err = wgaInit(dp, sk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, _ = dp.Routes(DEVICENAME)
+	if len(routes) != 0 {
+		t.Errorf("unexpected routes after restart %v", routes)
+	}
+}
+
+func TestWgaSync(t *testing.T) {
+	dp := testEndpoint(t)
+
+	alice, bob := mustKey(t).PublicKey(), mustKey(t).PublicKey()
+	cfg := &Config{
+		Peers: []v1beta.WireguardAccessPeer{
+			testPeer("alice", alice, nil, "fd00:1::1"),
+			testPeer("bob", bob, nil, "fd00:1::2", "10.0.0.2"),
+			{ObjectMeta: metav1.ObjectMeta{Name: "pending"}},
+		},
+	}
+
+	err := wgaSync(testLog, dp, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := devicePeer(t, dp, alice)
+	if p == nil {
+		t.Fatal("alice not configured")
+	}
+	if joinNets(p.AllowedIPs) != "fd00:1::1/128" {
+		t.Errorf("unexpected allowed ips for alice %s", joinNets(p.AllowedIPs))
+	}
+	if p.PersistentKeepaliveInterval != 60*time.Second {
+		t.Errorf("unexpected keepalive %s", p.PersistentKeepaliveInterval)
+	}
+
+	p = devicePeer(t, dp, bob)
+	if p == nil {
+		t.Fatal("bob not configured")
+	}
+	if joinNets(p.AllowedIPs) != "fd00:1::2/128,10.0.0.2/32" {
+		t.Errorf("unexpected allowed ips for bob %s", joinNets(p.AllowedIPs))
+	}
+
+	// nothing changed, nothing to do
+	dp.Reset()
+	err = wgaSync(testLog, dp, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dp.Changes()) != 0 {
+		t.Errorf("unexpected changes on resync: %v", dp.Changes())
+	}
+
+	// bob is deleted
+	cfg.Peers = cfg.Peers[:1]
+	err = wgaSync(testLog, dp, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devicePeer(t, dp, bob) != nil {
+		t.Error("bob was not removed")
+	}
+	if devicePeer(t, dp, alice) == nil {
+		t.Error("alice was removed")
+	}
+}
+
+func TestWgaSyncSkipsInvalidPeers(t *testing.T) {
+	dp := testEndpoint(t)
+
+	alice := mustKey(t).PublicKey()
+	invalid := testPeer("invalid", alice, nil, "fd00:1::2")
+	invalid.Spec.PublicKey = "not a key"
+
+	cfg := &Config{
+		Peers: []v1beta.WireguardAccessPeer{
+			testPeer("alice", alice, nil, "fd00:1::1"),
+			invalid,
+		},
+	}
+
+	err := wgaSync(testLog, dp, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, _ := dp.Device(DEVICENAME)
+	if len(dev.Peers) != 1 {
+		t.Errorf("expected only alice, got %d peers", len(dev.Peers))
+	}
+}
+
+func TestWGASync(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice := mustKey(t).PublicKey()
+	peer := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&peer, &rule)
+
+	err := WGASync(c, dp, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if devicePeer(t, dp, alice) == nil {
+		t.Error("alice not configured")
+	}
+
+	rules, err := dp.FilterRules(ctx, DEVICENAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Errorf("expected a routing, dns and http rule, got %v", rules)
+	}
+
+	if v, _ := dp.Sysctl(ctx, "net.ipv6.conf.all.forwarding"); v != "1" {
+		t.Error("forwarding not enabled")
+	}
+
+	err = c.Delete(ctx, &peer, &client.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WGASync(c, dp, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if devicePeer(t, dp, alice) != nil {
+		t.Error("alice not removed")
+	}
+
+	rules, _ = dp.FilterRules(ctx, DEVICENAME)
+	if len(rules) != 0 {
+		t.Errorf("stale rules left: %v", rules)
+	}
+}
+
+// countingClient counts the full syncs by their list of rules.
+type countingClient struct {
+	client.Client
+	syncs atomic.Int32
+}
+
+func (c *countingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if _, ok := list.(*v1beta.WireguardAccessRuleList); ok {
+		c.syncs.Add(1)
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestSyncerCoalesces(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice := mustKey(t).PublicKey()
+	peer := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+	rule := testRule("intranet", "fd00:2::/64")
+	c := &countingClient{Client: testClient(&peer, &rule)}
+
+	s := newSyncer(c, dp, testLog)
+
+	// a burst before the worker gets to it
+	var last uint64
+	for range 100 {
+		last = s.Request()
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.Start(workerCtx)
+
+	if err := s.Wait(ctx, last); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.syncs.Load(); n != 1 {
+		t.Errorf("expected the burst to be served by one sync, got %d", n)
+	}
+	if devicePeer(t, dp, alice) == nil {
+		t.Error("alice not configured")
+	}
+
+	// later requests get a sync of their own
+	if err := s.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.syncs.Load(); n != 2 {
+		t.Errorf("expected a second sync, got %d", n)
+	}
+}
+
+// failingDataplane fails to set up the filter chain.
+type failingDataplane struct {
+	*MemoryDataplane
+	err error
+}
+
+func (d failingDataplane) EnsureFilterChain(ctx context.Context, device string) error {
+	return d.err
+}
+
+func TestSyncFailures(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"transient", errors.New("netlink hiccup"), false},
+		{"permanent", fmt.Errorf("nft: %w", syscall.EPERM), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dp := failingDataplane{MemoryDataplane: testEndpoint(t), err: tc.err}
+
+			alice := mustKey(t).PublicKey()
+			peer := testPeer("alice", alice, []string{"intranet"}, "fd00:1::1")
+			rule := testRule("intranet", "fd00:2::/64")
+			c := testClient(&peer, &rule)
+
+			r := &PeerReconciler{client: c, recorder: record.NewFakeRecorder(100), dp: dp, syncer: testSyncer(t, c, dp), log: testLog}
+			res, err := r.Reconcile(ctx, &peer)
+			if tc.permanent {
+				if err != nil || res.RequeueAfter != SyncMaxBackoff {
+					t.Errorf("expected permanent failure to be checked back on at the sync worker's pace, got %v %v", res, err)
+				}
+			} else if err == nil {
+				t.Error("expected transient failure to be returned for a retry")
+			}
+
+			got := v1beta.WireguardAccessPeer{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(&peer), &got); err != nil {
+				t.Fatal(err)
+			}
+			reason := "SyncFailed"
+			if tc.permanent {
+				reason = "SyncFailedPermanently"
+			}
+			if cond := meta.FindStatusCondition(got.Status.Conditions, v1beta.ConditionDataplaneSynced); cond == nil || cond.Reason != reason {
+				t.Errorf("expected %s in status, got %v", reason, cond)
+			}
+
+			// the peer itself still made it to the device
+			if devicePeer(t, dp, alice) == nil {
+				t.Error("failing nft sync kept alice off the device")
+			}
+
+			// reconcilers don't wait out the worker's backoff
+			start := time.Now()
+			err = r.syncer.Sync(ctx)
+			if !errors.Is(err, tc.err) || time.Since(start) > SyncMinBackoff/2 {
+				t.Errorf("expected the failure right away during backoff, got %v after %v", err, time.Since(start))
+			}
+
+			err = WGASync(c, dp, testLog)
+			se := &SyncError{}
+			if !errors.As(err, &se) || se.Stage != SyncStageNFT || se.Permanent() != tc.permanent {
+				t.Errorf("expected %s nft sync error, got %#v", tc.name, err)
+			}
+		})
+	}
+}
+
+func TestPeerConditions(t *testing.T) {
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	alice := testPeer("alice", mustKey(t).PublicKey(), []string{"intranet"}, "fd00:1::1")
+	alice.Generation = 2
+	bob := testPeer("bob", mustKey(t).PublicKey(), []string{"intranet", "missing"}, "fd00:1::2")
+	broken := testPeer("broken", wgtypes.Key{}, nil, "fd00:1::3")
+	broken.Spec.PublicKey = "not a key"
+	rule := testRule("intranet", "fd00:2::/64", "not a cidr")
+
+	c := testClient(&alice, &bob, &broken, &rule)
+	recorder := record.NewFakeRecorder(100)
+	r := &PeerReconciler{client: c, recorder: recorder, dp: dp, syncer: testSyncer(t, c, dp), log: testLog}
+
+	for _, p := range []*v1beta.WireguardAccessPeer{&alice, &bob, &broken} {
+		if _, err := r.Reconcile(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(name string) *v1beta.WireguardAccessPeerStatus {
+		t.Helper()
+		p := v1beta.WireguardAccessPeer{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, &p); err != nil {
+			t.Fatal(err)
+		}
+		return p.Status
+	}
+
+	st := get("alice")
+	if !meta.IsStatusConditionTrue(st.Conditions, v1beta.ConditionReady) ||
+		!meta.IsStatusConditionTrue(st.Conditions, v1beta.ConditionDataplaneSynced) {
+		t.Errorf("alice not ready: %v", st.Conditions)
+	}
+	if st.ObservedGeneration != 2 {
+		t.Errorf("expected observed generation 2, got %d", st.ObservedGeneration)
+	}
+
+	st = get("bob")
+	if cond := meta.FindStatusCondition(st.Conditions, v1beta.ConditionReady); cond == nil || cond.Reason != "MissingAccessRule" {
+		t.Errorf("expected bob to miss a rule: %v", st.Conditions)
+	}
+
+	st = get("broken")
+	if !meta.IsStatusConditionTrue(st.Conditions, v1beta.ConditionInvalidSpec) ||
+		meta.IsStatusConditionTrue(st.Conditions, v1beta.ConditionReady) {
+		t.Errorf("expected broken to be invalid: %v", st.Conditions)
+	}
+
+	// kubectl describe explains what happened
+	close(recorder.Events)
+	events := []string{}
+	for e := range recorder.Events {
+		events = append(events, e)
+	}
+	for _, want := range []string{"Warning MissingAccessRule access rules not found: missing", "Warning InvalidSpec spec.publicKey"} {
+		if !slices.ContainsFunc(events, func(e string) bool { return strings.HasPrefix(e, want) }) {
+			t.Errorf("expected event %q, got %v", want, events)
+		}
+	}
+
+	rr := &RulesReconciler{client: c, recorder: record.NewFakeRecorder(100), syncer: testSyncer(t, c, dp), log: testLog}
+	if _, err := rr.Reconcile(ctx, &rule); err != nil {
+		t.Fatal(err)
+	}
+
+	got := v1beta.WireguardAccessRule{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "intranet"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status == nil || !meta.IsStatusConditionTrue(got.Status.Conditions, v1beta.ConditionInvalidSpec) {
+		t.Errorf("expected rule with invalid destination to be reported: %v", got.Status)
+	}
+}
+
+func TestPeerKeyGeneration(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "wga")
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	peer := testPeer("alice", wgtypes.Key{}, []string{"intranet"}, "fd00:1::1")
+	peer.Spec.PublicKey = ""
+	rule := testRule("intranet", "fd00:2::/64")
+
+	c := testClient(&peer, &rule)
+	r := &PeerReconciler{client: c, recorder: record.NewFakeRecorder(100), dp: dp, syncer: testSyncer(t, c, dp), log: testLog}
+
+	if _, err := r.Reconcile(ctx, &peer); err != nil {
+		t.Fatal(err)
+	}
+
+	got := v1beta.WireguardAccessPeer{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "alice"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	ref := got.Spec.PrivateKeySecretRef
+	if ref == nil || ref.Name != "wgap-alice" || ref.Namespace != "wga" {
+		t.Fatalf("unexpected secret ref %v", ref)
+	}
+
+	sk := corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "wga", Name: "wgap-alice"}, &sk); err != nil {
+		t.Fatal(err)
+	}
+	pk, err := wgtypes.ParseKey(string(sk.Data[SecretKeyName]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.PublicKey != pk.PublicKey().String() {
+		t.Errorf("public key %s doesn't match secret", got.Spec.PublicKey)
+	}
+	if len(sk.OwnerReferences) != 1 || sk.OwnerReferences[0].Name != "alice" {
+		t.Errorf("secret not owned by peer: %v", sk.OwnerReferences)
+	}
+
+	if _, err := r.Reconcile(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if devicePeer(t, dp, pk.PublicKey()) == nil {
+		t.Error("peer with generated key not configured")
+	}
+
+	if err := c.Get(ctx, client.ObjectKeyFromObject(&sk), &sk); err != nil {
+		t.Fatal(err)
+	}
+	config := string(sk.Data[SecretKeyConfig])
+	if !strings.Contains(config, "PrivateKey = "+pk.String()) || !strings.Contains(config, "fd00:1::1/128") {
+		t.Errorf("unexpected config in secret:\n%s", config)
+	}
+}
+
+func TestPreSharedKeySecrets(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "wga")
+	t.Setenv("NODE_NAME", "a")
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	pub := mustKey(t).PublicKey()
+	psk := mustKey(t)
+	nodeKey := mustKey(t).String()
+	serverPSK := mustKey(t)
+	peer := testPeer("alice", pub, []string{"intranet"}, "fd00:1::1")
+	peer.Spec.PreSharedKey = psk.String()
+	peer.Status.Peers = []v1beta.WireguardAccessPeerStatusPeer{
+		{PublicKey: mustKey(t).PublicKey().String(), Endpoint: "[2001:db8::1]:51820", PreSharedKey: serverPSK.String()},
+	}
+	rule := testRule("intranet", "fd00:2::/64")
+
+	wgc := v1beta.WireguardClusterClient{
+		ObjectMeta: metav1.ObjectMeta{Name: "office"},
+		Spec: v1beta.WireguardClusterClientSpec{
+			Server: v1beta.WireguardClusterClientSpecServer{
+				Endpoint:  "[2001:db8::1]:51820",
+				PublicKey: mustKey(t).PublicKey().String(),
+			},
+			Routes: []string{"fd00:2::/64"},
+			Nodes: []v1beta.WireguardClusterClientNode{
+				{NodeName: "a", Address: "fd00:1::1", PreSharedKey: psk.String(), PrivateKey: v1beta.WireguardClusterClientNodePrivateKey{Value: &nodeKey}},
+			},
+		},
+	}
+
+	c := testClient(&peer, &rule, &wgc)
+	r := &PeerReconciler{client: c, recorder: record.NewFakeRecorder(100), dp: dp, syncer: testSyncer(t, c, dp), log: testLog}
+
+	reconcile := func() {
+		t.Helper()
+		got := v1beta.WireguardAccessPeer{}
+		if err := c.Get(ctx, client.ObjectKey{Name: "alice"}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Reconcile(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconcile()
+	got := v1beta.WireguardAccessPeer{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "alice"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.PreSharedKey != "" || got.Spec.PreSharedKeySecretRef == nil {
+		t.Fatalf("preshared key not migrated: %+v", got.Spec)
+	}
+	stored, err := secretKey(ctx, c, *got.Spec.PreSharedKeySecretRef, SecretKeyPreSharedKey)
+	if err != nil || stored != psk {
+		t.Fatalf("preshared key not stored in secret: %v", err)
+	}
+
+	reconcile()
+	if p := devicePeer(t, dp, pub); p == nil || p.PresharedKey != psk {
+		t.Errorf("peer not configured with preshared key from secret: %v", p)
+	}
+
+	if err := c.Get(ctx, client.ObjectKey{Name: "alice"}, &got); err != nil {
+		t.Fatal(err)
+	}
+	sp := got.Status.Peers[0]
+	if sp.PreSharedKey != "" || sp.PreSharedKeySecretRef == nil || sp.PreSharedKeySecretRef.Namespace != "wga" {
+		t.Fatalf("server peer preshared key not migrated: %+v", sp)
+	}
+	stored, err = secretKey(ctx, c, *sp.PreSharedKeySecretRef, SecretKeyPreSharedKey)
+	if err != nil || stored != serverPSK {
+		t.Fatalf("server peer preshared key not stored in secret: %v", err)
+	}
+	resolved, err := resolveStatusPreSharedKeys(ctx, c, &got, "")
+	if err != nil || resolved.Status.Peers[0].PreSharedKey != serverPSK.String() {
+		t.Errorf("server peer preshared key not resolved from secret: %v", err)
+	}
+
+	wr := &ClusterClientReconciler{client: c, recorder: record.NewFakeRecorder(100), dp: dp, log: testLog}
+	for range 2 {
+		got := v1beta.WireguardClusterClient{}
+		if err := c.Get(ctx, client.ObjectKey{Name: "office"}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wr.Reconcile(ctx, &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gotWGC := v1beta.WireguardClusterClient{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "office"}, &gotWGC); err != nil {
+		t.Fatal(err)
+	}
+	node := gotWGC.Spec.Nodes[0]
+	if node.PreSharedKey != "" || node.PreSharedKeySecretRef == nil || node.PreSharedKeySecretRef.Name != "wgc-office-a" {
+		t.Fatalf("node preshared key not migrated: %+v", node)
+	}
+
+	dev, err := dp.Device("wgc-office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dev.Peers) != 1 || dev.Peers[0].PresharedKey != psk {
+		t.Errorf("cluster client not configured with preshared key from secret: %v", dev.Peers)
+	}
+}
+
+func TestClusterClientMissingKeySecret(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "wga")
+	t.Setenv("NODE_NAME", "a")
+	dp := testEndpoint(t)
+	ctx := context.Background()
+
+	wgc := func(ref corev1.SecretReference) *v1beta.WireguardClusterClient {
+		return &v1beta.WireguardClusterClient{
+			ObjectMeta: metav1.ObjectMeta{Name: "office"},
+			Spec: v1beta.WireguardClusterClientSpec{
+				Server: v1beta.WireguardClusterClientSpecServer{
+					Endpoint:  "[2001:db8::1]:51820",
+					PublicKey: mustKey(t).PublicKey().String(),
+				},
+				Routes: []string{"fd00:2::/64"},
+				Nodes: []v1beta.WireguardClusterClientNode{
+					{NodeName: "a", Address: "fd00:1::1", PrivateKey: v1beta.WireguardClusterClientNodePrivateKey{SecretRef: &ref}},
+				},
+			},
+		}
+	}
+
+	reconcile := func(c client.Client) (v1beta.WireguardClusterClient, error) {
+		t.Helper()
+		r := &ClusterClientReconciler{client: c, recorder: record.NewFakeRecorder(100), dp: dp, log: testLog}
+		got := v1beta.WireguardClusterClient{}
+		if err := c.Get(ctx, client.ObjectKey{Name: "office"}, &got); err != nil {
+			t.Fatal(err)
+		}
+		_, err := r.Reconcile(ctx, &got)
+		if gerr := c.Get(ctx, client.ObjectKey{Name: "office"}, &got); gerr != nil {
+			t.Fatal(gerr)
+		}
+		return got, err
+	}
+
+	t.Run("defaulted", func(t *testing.T) {
+		c := testClient(wgc(corev1.SecretReference{Name: "wgc-office-a"}))
+		if _, err := reconcile(c); err != nil {
+			t.Fatal(err)
+		}
+
+		sk := corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "wga", Name: "wgc-office-a"}, &sk); err != nil {
+			t.Fatalf("key secret not generated: %v", err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		ref  corev1.SecretReference
+	}{
+		{"explicit namespace", corev1.SecretReference{Name: "wgc-office-a", Namespace: "office"}},
+		{"explicit name", corev1.SecretReference{Name: "office-key"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testClient(wgc(tc.ref))
+			got, err := reconcile(c)
+			if err == nil {
+				t.Fatal("expected an error for a missing secret")
+			}
+			if !meta.IsStatusConditionTrue(got.Status.Conditions, v1beta.ConditionInvalidSpec) {
+				t.Errorf("missing secret not reported as invalid spec: %v", got.Status.Conditions)
+			}
+
+			secrets := corev1.SecretList{}
+			if err := c.List(ctx, &secrets); err != nil {
+				t.Fatal(err)
+			}
+			if len(secrets.Items) != 0 {
+				t.Errorf("key replaced by generated secret %s/%s", secrets.Items[0].Namespace, secrets.Items[0].Name)
+			}
+		})
+	}
+}
+
+func TestPeersWithSecret(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "wga")
+	ctx := context.Background()
+
+	alice := testPeer("alice", mustKey(t).PublicKey(), nil, "fd00:1::1")
+	alice.Spec.PreSharedKeySecretRef = &corev1.SecretReference{Name: "wgap-alice"}
+	bob := testPeer("bob", mustKey(t).PublicKey(), nil, "fd00:1::2")
+	bob.Spec.PreSharedKeySecretRef = &corev1.SecretReference{Name: "wgap-alice", Namespace: "other"}
+	carol := testPeer("carol", mustKey(t).PublicKey(), nil, "fd00:1::3")
+	c := testClient(&alice, &bob, &carol)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "wgap-alice", Namespace: "wga"}}
+	reqs := peersWithSecret(ctx, c, secret)
+	if len(reqs) != 1 || reqs[0].Name != "alice" {
+		t.Errorf("expected only alice, got %v", reqs)
+	}
+}
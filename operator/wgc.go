@@ -12,12 +12,13 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
-	"github.com/vishvananda/netlink"
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -48,8 +49,10 @@ var clientPredicate = &predicate.TypedFuncs[client.Object]{
 func RunWGC(
 	ctx context.Context,
 	config *rest.Config,
+	dp Dataplane,
+	dryRun bool,
 ) {
-	mgr, err := manager.New(config, manager.Options{})
+	mgr, err := manager.New(config, managerOptions(dryRun))
 	if err != nil {
 		slog.Error("unable to create new manager", "err", err)
 		os.Exit(1)
@@ -57,25 +60,76 @@ func RunWGC(
 
 	log.SetLogger(logr.FromSlogHandler(slog.With("component", "wgc-controller").Handler()))
 
-	registerClusterClientReconciler(mgr)
+	registerClusterClientReconciler(mgr, dp)
+	registerWGCMetrics(mgr, dp)
 
-	if err = mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
-		slog.Error("unable to set up health check", "err", err)
+	err = addHealthChecks(mgr, map[string]healthz.Checker{
+		"health": healthz.Ping,
+	}, map[string]healthz.Checker{
+		"devices": checkWGCDevices(dp),
+	})
+	if err != nil {
+		slog.Error("unable to set up health checks", "err", err)
 		os.Exit(1)
 	}
 
-	if err = mgr.AddReadyzCheck("check", healthz.Ping); err != nil {
-		slog.Error("unable to set up ready check", "err", err)
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		slog.Error("unable to start manager", "err", err)
 		os.Exit(1)
 	}
+}
 
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		slog.Error("unable to start manager", "err", err)
+// registerWGCMetrics polls the wgc-* devices for their handshake age.
+func registerWGCMetrics(mgr ctrl.Manager, dp Dataplane) {
+	err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		ticker := time.NewTicker(StatusInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if err := wgcCollect(dp); err != nil {
+					slog.Error("unable to collect wgc metrics", "err", err)
+				}
+			}
+		}
+	}))
+	if err != nil {
+		slog.Error("unable to create wgc metrics collector", "err", err)
 		os.Exit(1)
 	}
 }
 
-func registerClusterClientReconciler(mgr ctrl.Manager) {
+func wgcCollect(dp Dataplane) error {
+	names, err := dp.Links("wgc-")
+	if err != nil {
+		return fmt.Errorf("cannot list wgc interfaces: %w", err)
+	}
+
+	now := time.Now()
+	wgcHandshakeAge.Reset()
+	for _, name := range names {
+		d, err := dp.Device(name)
+		if err != nil {
+			return fmt.Errorf("wg.Device(%s): %w", name, err)
+		}
+
+		age := -1.0
+		for _, p := range d.Peers {
+			if !p.LastHandshakeTime.IsZero() {
+				age = now.Sub(p.LastHandshakeTime).Seconds()
+			}
+		}
+
+		wgcHandshakeAge.WithLabelValues(d.Name).Set(age)
+	}
+
+	return nil
+}
+
+func registerClusterClientReconciler(mgr ctrl.Manager, dp Dataplane) {
 	err := ctrl.NewControllerManagedBy(mgr).
 		For(&v1beta.WireguardClusterClient{}, builder.WithPredicates(clientPredicate)).
 		WithEventFilter(clientPredicate).
@@ -84,8 +138,10 @@ func registerClusterClientReconciler(mgr ctrl.Manager) {
 			return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(o)}}
 		}), builder.WithPredicates(clientPredicate)).
 		Complete(reconcile.AsReconciler(mgr.GetClient(), &ClusterClientReconciler{
-			client: mgr.GetClient(),
-			log:    slog.With("component", "wgc-reconciler"),
+			client:   mgr.GetClient(),
+			recorder: mgr.GetEventRecorderFor("wga-clusterclient"),
+			dp:       dp,
+			log:      slog.With("component", "wgc-reconciler"),
 		}))
 	if err != nil {
 		slog.Error("unable to create controller", "err", err)
@@ -94,8 +150,10 @@ func registerClusterClientReconciler(mgr ctrl.Manager) {
 }
 
 type ClusterClientReconciler struct {
-	client client.Client
-	log    *slog.Logger
+	client   client.Client
+	recorder record.EventRecorder
+	dp       Dataplane
+	log      *slog.Logger
 }
 
 const (
@@ -141,10 +199,18 @@ func (r *ClusterClientReconciler) Reconcile(ctx context.Context, c *v1beta.Wireg
 	}
 
 	peers := []wgPeer{}
+	configured := []wgcStatus{}
 	for _, wg := range wgcs.Items {
 		r.log.Info("WireguardClusterClient", "name", wg.Name)
 
-		updateWgc := false
+		if wg.Status == nil {
+			wg.Status = &v1beta.WireguardClusterClientStatus{}
+		}
+		if wg.Status.Nodes == nil {
+			wg.Status.Nodes = []v1beta.WireguardClusterClientStatusNode{}
+		}
+		old := wg.Status.DeepCopy()
+
 		node := v1beta.WireguardClusterClientNode{}
 		for _, n := range wg.Spec.Nodes {
 			if n.NodeName == nodeName {
@@ -157,66 +223,58 @@ func (r *ClusterClientReconciler) Reconcile(ctx context.Context, c *v1beta.Wireg
 			continue
 		}
 
+		// the spec update brings the cluster client back without it
+		if _, err := wgtypes.ParseKey(node.PreSharedKey); node.PreSharedKey != "" && err == nil {
+			return ctrl.Result{}, r.migratePreSharedKey(ctx, &wg, nodeName)
+		}
+
+		psk, err := nodePreSharedKey(ctx, r.client, &wg, node)
+		if err != nil {
+			return ctrl.Result{}, r.invalidSpec(ctx, &wg, err)
+		}
+
 		peerPrivateKey := node.PrivateKey.Value
 		if peerPrivateKey == nil {
 			ref := node.PrivateKey.SecretRef
 			if ref == nil {
-				return ctrl.Result{}, fmt.Errorf("privateKey.value or privateKey.secretRef must be set")
+				return ctrl.Result{}, r.invalidSpec(ctx, &wg, fmt.Errorf("privateKey.value or privateKey.secretRef must be set"))
 			}
 
-			skNamespace := ref.Namespace
-			if skNamespace == "" {
-				skNamespace = getK8sNamespace()
-			}
+			// only the secret of a defaulted reference gets a generated key. A secret named explicitly
+			// holds a key the server was told about, generating another one would never connect
+			generate := ref.Name == "" || (ref.Name == formatSecretName(nodeName, wg.Name) && ref.Namespace == "")
 
-			skName := ref.Name
-			if skName == "" {
-				skName = formatSecretName(nodeName, wg.Name)
-				updateWgc = true
+			skRef := withNamespace(*ref, getK8sNamespace())
+			if skRef.Name == "" {
+				skRef.Name = formatSecretName(nodeName, wg.Name)
 			}
 
-			sk := new(corev1.Secret)
-			err := r.client.Get(ctx, client.ObjectKey{
-				Namespace: skNamespace,
-				Name:      skName,
-			}, sk)
-			if err != nil {
-				privk, err := wgtypes.GenerateKey()
+			privk, err := secretKey(ctx, r.client, skRef, SecretKeyName)
+			if missingSecretKey(err) && !generate {
+				return ctrl.Result{}, r.invalidSpec(ctx, &wg, fmt.Errorf("private key of node %s: %w", nodeName, err))
+			}
+			if missingSecretKey(err) {
+				privk, err = wgtypes.GeneratePrivateKey()
 				if err != nil {
 					return ctrl.Result{}, fmt.Errorf("error generating key: %w", err)
 				}
 
-				sk = &corev1.Secret{
-					ObjectMeta: metav1.ObjectMeta{
-						Namespace: skNamespace,
-						Name:      skName,
-					},
-					Data: map[string][]byte{
-						SecretKeyName: []byte(privk.String()),
-					},
-				}
-				err = r.client.Create(ctx, sk)
-				if err != nil {
-					return ctrl.Result{}, fmt.Errorf("error creating secret: %w", err)
+				err = storeSecretKey(ctx, r.client, skRef, SecretKeyName, []byte(privk.String()), nil, nil, nil)
+				if err == nil {
+					r.recorder.Eventf(&wg, corev1.EventTypeNormal, "KeyGenerated", "generated private key of node %s in secret %s/%s", nodeName, skRef.Namespace, skRef.Name)
 				}
 			}
+			if err != nil {
+				return ctrl.Result{}, err
+			}
 
-			skdata := string(sk.Data[SecretKeyName])
+			skdata := privk.String()
 			peerPrivateKey = &skdata
-			updateWgc = true
 		}
 
 		privk, err := wgtypes.ParseKey(*peerPrivateKey)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("error parsing key: %w", err)
-		}
-
-		if wg.Status == nil {
-			wg.Status = &v1beta.WireguardClusterClientStatus{}
-		}
-
-		if wg.Status.Nodes == nil {
-			wg.Status.Nodes = []v1beta.WireguardClusterClientStatusNode{}
+			return ctrl.Result{}, r.invalidSpec(ctx, &wg, fmt.Errorf("error parsing key: %w", err))
 		}
 
 		peerFound := false
@@ -225,7 +283,6 @@ func (r *ClusterClientReconciler) Reconcile(ctx context.Context, c *v1beta.Wireg
 				peerFound = true
 				if p.PublicKey != privk.PublicKey().String() {
 					wg.Status.Nodes[i].PublicKey = privk.PublicKey().String()
-					updateWgc = true
 				}
 				break
 			}
@@ -236,15 +293,6 @@ func (r *ClusterClientReconciler) Reconcile(ctx context.Context, c *v1beta.Wireg
 				NodeName:  nodeName,
 				PublicKey: privk.PublicKey().String(),
 			})
-			updateWgc = true
-		}
-
-		if updateWgc {
-			r.log.Info("Updating WireguardClusterClient", "name", wg.Name)
-			err = r.client.Update(ctx, &wg)
-			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("error updating wgc: %w", err)
-			}
 		}
 
 		for i := range wg.Spec.Nodes {
@@ -256,25 +304,28 @@ func (r *ClusterClientReconciler) Reconcile(ctx context.Context, c *v1beta.Wireg
 
 		serverPublicKey, err := wgtypes.ParseKey(wg.Spec.Server.PublicKey)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("error parsing server public key: %w", err)
+			return ctrl.Result{}, r.invalidSpec(ctx, &wg, fmt.Errorf("error parsing server public key: %w", err))
 		}
 
 		routes := []net.IPNet{}
-		for _, r := range wg.Spec.Routes {
-			_, snet, err := net.ParseCIDR(r)
+		for _, route := range wg.Spec.Routes {
+			_, snet, err := net.ParseCIDR(route)
 			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("error parsing route: %w", err)
+				return ctrl.Result{}, r.invalidSpec(ctx, &wg, fmt.Errorf("error parsing route: %w", err))
 			}
 			routes = append(routes, *snet)
 		}
 
+		setCondition(&wg.Status.Conditions, wg.Generation, v1beta.ConditionInvalidSpec, false, "Valid", "")
+		configured = append(configured, wgcStatus{wgc: wg, old: old})
+
 		peer := wgPeer{
 			PeerName:            wg.Name,
 			PeerAddress:         node.Address,
 			PeerPrivateKey:      privk,
 			ServerPublicKey:     serverPublicKey,
 			Routes:              routes,
-			PreSharedKey:        node.PreSharedKey,
+			PreSharedKey:        psk,
 			ServerEndpoint:      wg.Spec.Server.Endpoint,
 			PersistentKeepalive: wg.Spec.PersistentKeepalive,
 		}
@@ -282,9 +333,24 @@ func (r *ClusterClientReconciler) Reconcile(ctx context.Context, c *v1beta.Wireg
 		peers = append(peers, peer)
 	}
 
-	err = wgcSync(r.log, peers)
-	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("error syncing wgc: %w", err)
+	syncErr := wgcSync(r.log, r.dp, peers)
+	if syncErr == nil {
+		recordWGC(peers)
+	}
+
+	for _, c := range configured {
+		if syncErr != nil {
+			r.recorder.Eventf(&c.wgc, corev1.EventTypeWarning, "SyncFailed", "node %s: %s", nodeName, syncErr.Error())
+		}
+
+		err = r.updateStatus(ctx, nodeName, c, syncErr)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error updating wgc status: %w", err)
+		}
+	}
+
+	if syncErr != nil {
+		return ctrl.Result{}, fmt.Errorf("error syncing wgc: %w", syncErr)
 	}
 
 	// if sync passed, update node labels to reflect we can use wgc
@@ -298,6 +364,72 @@ func (r *ClusterClientReconciler) Reconcile(ctx context.Context, c *v1beta.Wireg
 	return ctrl.Result{}, nil
 }
 
+// wgcStatus is a WireguardClusterClient configured on this node and its status before the reconcile.
+type wgcStatus struct {
+	wgc v1beta.WireguardClusterClient
+	old *v1beta.WireguardClusterClientStatus
+}
+
+// updateStatus records the outcome of the sync on this node in the status of the cluster client.
+// The client is ready once every node in the spec reports its dataplane as synced.
+func (r *ClusterClientReconciler) updateStatus(ctx context.Context, nodeName string, c wgcStatus, syncErr error) error {
+	wg := c.wgc
+
+	for i := range wg.Status.Nodes {
+		if wg.Status.Nodes[i].NodeName != nodeName {
+			continue
+		}
+
+		if syncErr != nil {
+			setCondition(&wg.Status.Nodes[i].Conditions, wg.Generation, v1beta.ConditionDataplaneSynced, false, "SyncFailed", syncErr.Error())
+		} else {
+			setCondition(&wg.Status.Nodes[i].Conditions, wg.Generation, v1beta.ConditionDataplaneSynced, true, "Synced", "")
+		}
+	}
+
+	synced := map[string]bool{}
+	for _, n := range wg.Status.Nodes {
+		synced[n.NodeName] = meta.IsStatusConditionTrue(n.Conditions, v1beta.ConditionDataplaneSynced)
+	}
+
+	pending := []string{}
+	for _, n := range wg.Spec.Nodes {
+		if !synced[n.NodeName] {
+			pending = append(pending, n.NodeName)
+		}
+	}
+
+	if len(pending) > 0 {
+		setCondition(&wg.Status.Conditions, wg.Generation, v1beta.ConditionReady, false, "NodesPending", "nodes not synced: "+strings.Join(pending, ", "))
+	} else {
+		setCondition(&wg.Status.Conditions, wg.Generation, v1beta.ConditionReady, true, "Ready", "")
+	}
+	wg.Status.ObservedGeneration = wg.Generation
+
+	if equality.Semantic.DeepEqual(c.old, wg.Status) {
+		return nil
+	}
+
+	r.log.Info("Updating WireguardClusterClient status", "name", wg.Name)
+	return r.client.Status().Update(ctx, &wg)
+}
+
+// invalidSpec records err as InvalidSpec condition and returns it.
+func (r *ClusterClientReconciler) invalidSpec(ctx context.Context, wg *v1beta.WireguardClusterClient, err error) error {
+	changed := setCondition(&wg.Status.Conditions, wg.Generation, v1beta.ConditionInvalidSpec, true, "ValidationFailed", err.Error())
+	changed = setCondition(&wg.Status.Conditions, wg.Generation, v1beta.ConditionReady, false, "InvalidSpec", err.Error()) || changed
+	wg.Status.ObservedGeneration = wg.Generation
+
+	if changed {
+		r.recorder.Eventf(wg, corev1.EventTypeWarning, "InvalidSpec", "node %s: %s", getK8sNode(), err.Error())
+		if uerr := r.client.Status().Update(ctx, wg); uerr != nil {
+			r.log.Error("Error updating wgc status", "name", wg.Name, "error", uerr)
+		}
+	}
+
+	return err
+}
+
 func getK8sNode() string {
 	if ns, ok := os.LookupEnv("NODE_NAME"); ok {
 		return ns
@@ -337,57 +469,30 @@ type wgPeer struct {
 	ServerEndpoint      string
 }
 
-func wgcSync(log *slog.Logger, wgc []wgPeer) error {
+func wgcSync(log *slog.Logger, dp Dataplane, wgc []wgPeer) error {
 	// list existing interfaces
-	lnks, err := netlink.LinkList()
+	lnks, err := dp.Links("wgc-")
 	if err != nil {
 		return err
 	}
 
-	existing := make(map[string]netlink.Link)
+	existing := make(map[string]bool)
 	for _, lnk := range lnks {
-		if lnk.Type() != "wireguard" {
-			continue
-		}
-
-		if !strings.HasPrefix(lnk.Attrs().Name, "wgc-") {
-			continue
-		}
-
-		existing[lnk.Attrs().Name] = lnk
+		existing[lnk] = true
 	}
 
 	// sync
 	for _, wgc := range wgc {
 		ifname := "wgc-" + wgc.PeerName
-		if _, ok := existing[ifname]; ok {
+		if existing[ifname] {
 			delete(existing, ifname)
 		} else {
-			wirelink := &netlink.GenericLink{
-				LinkAttrs: netlink.LinkAttrs{
-					Name: ifname,
-				},
-				LinkType: "wireguard",
-			}
-			err = netlink.LinkAdd(wirelink)
+			err = dp.AddLink(ifname)
 			if err != nil {
 				return fmt.Errorf("cannot create wg interface: %w", err)
 			}
 		}
 
-		link, err := netlink.LinkByName(ifname)
-		if err != nil {
-			return fmt.Errorf("cannot get wg interface: %w", err)
-		}
-
-		wg, err := wgctrl.New()
-		if err != nil {
-			return fmt.Errorf("wgctrl.New: %w", err)
-		}
-		defer wg.Close()
-
-		WGConfig.PrivateKey = &wgc.PeerPrivateKey
-
 		epa, err := netip.ParseAddrPort(wgc.ServerEndpoint)
 		if err != nil {
 			return fmt.Errorf("error parsing endpoint: %w", err)
@@ -414,14 +519,16 @@ func wgcSync(log *slog.Logger, wgc []wgPeer) error {
 			pc.PersistentKeepaliveInterval = &ka
 		}
 
-		WGConfig.Peers = append(WGConfig.Peers, pc)
-
-		err = wg.ConfigureDevice(ifname, WGConfig)
+		err = dp.ConfigureDevice(ifname, wgtypes.Config{
+			PrivateKey:   &wgc.PeerPrivateKey,
+			ReplacePeers: true,
+			Peers:        []wgtypes.PeerConfig{pc},
+		})
 		if err != nil {
 			return fmt.Errorf("wgctrl.ConfigureDevice: %w", err)
 		}
 
-		err = netlink.LinkSetUp(link)
+		err = dp.LinkUp(ifname)
 		if err != nil {
 			return fmt.Errorf("link up: %w", err)
 		}
@@ -444,18 +551,16 @@ func wgcSync(log *slog.Logger, wgc []wgPeer) error {
 
 		log.Info("syncing WireguardClusterClient", "name", wgc.PeerName, "address", addr)
 
-		err = netlink.AddrReplace(link, &netlink.Addr{
-			IPNet: addr,
-		})
+		err = dp.AddrReplace(ifname, *addr)
 		if err != nil {
 			return fmt.Errorf("cannot add address: %w", err)
 		}
 
 		// if addr not in wgc.Spec.Addresses, delete it
-		addrs, _ := netlink.AddrList(link, netlink.FAMILY_ALL)
+		addrs, _ := dp.Addrs(ifname)
 		for _, addr2 := range addrs {
-			if addr.String() != addr2.IPNet.String() {
-				if err := netlink.AddrDel(link, &addr2); err != nil {
+			if addr.String() != addr2.String() {
+				if err := dp.AddrDel(ifname, addr2); err != nil {
 					log.Error("Error deleting old address", "addr", addr, "error", err)
 					return err
 				}
@@ -463,17 +568,14 @@ func wgcSync(log *slog.Logger, wgc []wgPeer) error {
 		}
 
 		for _, dst := range wgc.Routes {
-			err = netlink.RouteReplace(&netlink.Route{
-				LinkIndex: link.Attrs().Index,
-				Dst:       &dst,
-			})
+			err = dp.RouteReplace(ifname, dst)
 			if err != nil {
 				return fmt.Errorf("cannot add route: %w", err)
 			}
 		}
 
 		// get existing routes
-		hasRoutes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+		hasRoutes, err := dp.Routes(ifname)
 		if err != nil {
 			return fmt.Errorf("cannot get routes: %w", err)
 		}
@@ -481,13 +583,13 @@ func wgcSync(log *slog.Logger, wgc []wgPeer) error {
 		for _, hasRoute := range hasRoutes {
 			delete := true
 			for _, route := range wgc.Routes {
-				if hasRoute.Dst.String() == route.String() {
+				if hasRoute.String() == route.String() {
 					delete = false
 				}
 			}
 
 			if delete {
-				if err := netlink.RouteDel(&hasRoute); err != nil {
+				if err := dp.RouteDel(ifname, hasRoute); err != nil {
 					log.Error("Error deleting old route", "route", hasRoute, "error", err)
 					return err
 				}
@@ -498,8 +600,8 @@ func wgcSync(log *slog.Logger, wgc []wgPeer) error {
 	}
 
 	// delete leftovers
-	for n, lnk := range existing {
-		if err := netlink.LinkDel(lnk); err != nil {
+	for n := range existing {
+		if err := dp.DelLink(n); err != nil {
 			log.Error("Error deleting old wg interface", "if", n, "error", err)
 			return err
 		}
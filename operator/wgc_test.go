@@ -0,0 +1,96 @@
+package operator
+
+import (
+	"net"
+	"testing"
+)
+
+func TestWGCSync(t *testing.T) {
+	dp := NewMemoryDataplane(nil)
+	server := mustKey(t).PublicKey()
+
+	peers := []wgPeer{
+		{
+			PeerName:            "east",
+			PeerPrivateKey:      mustKey(t),
+			PeerAddress:         "fd00:1::10",
+			PersistentKeepalive: 25,
+			ServerPublicKey:     server,
+			Routes:              []net.IPNet{mustCIDR(t, "fd00:2::/64")},
+			ServerEndpoint:      "[2001:db8::1]:51820",
+		},
+		{
+			PeerName:        "west",
+			PeerPrivateKey:  mustKey(t),
+			PeerAddress:     "10.0.0.10/24",
+			ServerPublicKey: server,
+			Routes:          []net.IPNet{mustCIDR(t, "10.2.0.0/16"), mustCIDR(t, "10.3.0.0/16")},
+			ServerEndpoint:  "192.0.2.1:51820",
+		},
+	}
+
+	err := wgcSync(testLog, dp, peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range peers {
+		ifname := "wgc-" + p.PeerName
+		dev, err := dp.Device(ifname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dev.PublicKey != p.PeerPrivateKey.PublicKey() {
+			t.Errorf("%s: unexpected key", ifname)
+		}
+		if len(dev.Peers) != 1 || dev.Peers[0].PublicKey != server {
+			t.Errorf("%s: expected only the server as peer, got %v", ifname, dev.Peers)
+		}
+		if dev.Peers[0].Endpoint.String() != p.ServerEndpoint {
+			t.Errorf("%s: unexpected endpoint %s", ifname, dev.Peers[0].Endpoint)
+		}
+		if !dp.IsUp(ifname) {
+			t.Errorf("%s: not up", ifname)
+		}
+
+		routes, _ := dp.Routes(ifname)
+		if joinNets(routes) != joinNets(p.Routes) {
+			t.Errorf("%s: unexpected routes %s", ifname, joinNets(routes))
+		}
+	}
+
+	addrs, _ := dp.Addrs("wgc-west")
+	if joinNets(addrs) != "10.0.0.10/24" {
+		t.Errorf("unexpected addresses %s", joinNets(addrs))
+	}
+
+	// nothing changed, only the device config is rewritten
+	dp.Reset()
+	err = wgcSync(testLog, dp, peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range dp.Changes() {
+		if c[:3] != "wg " {
+			t.Errorf("unexpected change on resync: %s", c)
+		}
+	}
+
+	// west drops a route, east is deleted
+	peers[1].Routes = peers[1].Routes[:1]
+	err = wgcSync(testLog, dp, peers[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links, _ := dp.Links("wgc-")
+	if len(links) != 1 || links[0] != "wgc-west" {
+		t.Errorf("unexpected links %v", links)
+	}
+
+	routes, _ := dp.Routes("wgc-west")
+	if joinNets(routes) != "10.2.0.0/16" {
+		t.Errorf("unexpected routes %s", joinNets(routes))
+	}
+}
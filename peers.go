@@ -1,31 +1,53 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
-	"net"
-	"net/netip"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/kraudcloud/wga/operator"
 	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"github.com/kraudcloud/wga/pkgs/clientconfig"
+	"github.com/skip2/go-qrcode"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
 )
 
 func peerCmd() *cobra.Command {
 	rules := []string{}
+	var ttl time.Duration
+	serverKey := false
+	addNamespace := ""
+	publicKey := ""
+	publicKeyFile := ""
+	privateKeyFile := ""
+	pskFile := ""
+	noPSK := false
+	addOut := &configOutput{}
 
 	cmd := &cobra.Command{
 		Use:     "peer",
@@ -41,29 +63,101 @@ func peerCmd() *cobra.Command {
 			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 			defer cancel()
 
-			pk, err := wgtypes.GenerateKey()
+			// pk stays empty if the user brings their own key, the config then gets a placeholder or privateKeyFile
+			if privateKeyFile != "" && publicKey == "" && publicKeyFile == "" {
+				exit("--private-key-file needs --public-key or --public-key-file")
+			}
+			if publicKeyFile == "-" && pskFile == "-" {
+				exit("only one of --public-key-file and --psk-file can be read from stdin")
+			}
+
+			var pk, pub wgtypes.Key
+			var err error
+			switch {
+			case publicKey != "":
+				pub, err = wgtypes.ParseKey(publicKey)
+			case publicKeyFile != "":
+				pub, err = readKeyFile(publicKeyFile)
+			default:
+				pk, err = wgtypes.GenerateKey()
+				pub = pk.PublicKey()
+			}
 			if err != nil {
-				exit("unable to generate key", "err", err)
+				exit("unable to get public key", "err", err)
 			}
 
-			psk, err := wgtypes.GenerateKey()
+			// a zero psk creates the peer without one
+			var psk wgtypes.Key
+			switch {
+			case noPSK:
+			case pskFile != "":
+				psk, err = readKeyFile(pskFile)
+			default:
+				psk, err = wgtypes.GenerateKey()
+			}
 			if err != nil {
-				exit("unable to generate psk", "err", err)
+				exit("unable to get psk", "err", err)
 			}
 
-			peer, err := NewWGAPeer(ctx, args[0], rules, pk, psk, clientConfig())
+			spec := v1beta.WireguardAccessPeerSpec{
+				AccessRules: rules,
+				PublicKey:   pub.String(),
+			}
+			if serverKey {
+				spec.PublicKey = ""
+			}
+			if ttl > 0 {
+				spec.ExpiresAt = ptr(v1.NewTime(time.Now().Add(ttl).Truncate(time.Second)))
+			}
+
+			if addNamespace == "" {
+				addNamespace = clientNamespace()
+			}
+
+			config := clientConfig()
+			peer, err := newPeerWithPSK(ctx, args[0], spec, psk, addNamespace, config)
 			if err != nil {
 				exit("unable to create peer", "err", err)
 			}
 
-			FormatPeerIni(*peer, peer.Status.DNS, pk, psk)
+			var cfg clientconfig.ConfigFile
+			if serverKey {
+				cfg, err = peerSecretConfig(ctx, *peer, addNamespace, config)
+			} else {
+				cfg, err = clientconfig.FromPeer(*peer, pk, psk)
+				cfg.PrivateKeyFile = privateKeyFile
+			}
+			if err != nil {
+				exit("unable to get peer config", "err", err)
+			}
+
+			err = addOut.print(cfg)
+			if err != nil {
+				exit("unable to print peer config", "err", err)
+			}
 		},
 		Aliases: []string{"new"},
 	}
 	add.Flags().StringSliceVarP(&rules, "rules", "r", rules, "rules to apply to this peer")
+	add.Flags().DurationVar(&ttl, "ttl", ttl, "remove the peer from the endpoint after this duration, eg. 72h")
+	add.Flags().BoolVar(&serverKey, "server-key", serverKey, "let the endpoint generate the private key and keep it in a secret")
+	add.Flags().StringVar(&publicKey, "public-key", publicKey, "register this public key instead of generating a key pair, the private key never leaves the client")
+	add.Flags().StringVar(&publicKeyFile, "public-key-file", publicKeyFile, "file holding the public key to register, as written by wg pubkey, - for stdin")
+	add.Flags().StringVar(&privateKeyFile, "private-key-file", privateKeyFile, "path of the private key on the client, the config reads it from there instead of holding a placeholder. Only with --public-key or --public-key-file")
+	add.Flags().StringVar(&pskFile, "psk-file", pskFile, "file holding the preshared key to use instead of generating one, as written by wg genpsk, - for stdin")
+	add.Flags().BoolVar(&noPSK, "no-psk", noPSK, "create the peer without a preshared key")
+	add.MarkFlagsMutuallyExclusive("public-key", "public-key-file", "server-key")
+	add.MarkFlagsMutuallyExclusive("private-key-file", "server-key")
+	add.MarkFlagsMutuallyExclusive("psk-file", "no-psk")
+	add.Flags().StringVar(&addNamespace, "endpoint-namespace", addNamespace, "namespace the endpoint runs in, where the preshared key secret is created and secret references without namespace point. Defaults to the kubeconfig's namespace")
+	addOut.flags(add)
 	cmd.AddCommand(add)
 
 	wgcNodes := []string{}
+	wgcNamespace := ""
+	wgcEndpointNamespace := ""
+	wgcOutput := "yaml"
+	wgcTarget := targetCluster{}
 	wgc := &cobra.Command{
 		Use:   "wgc",
 		Short: "generate a configuration for a WireguardAccessClient",
@@ -75,10 +169,36 @@ func peerCmd() *cobra.Command {
 			if len(wgcNodes) == 0 {
 				exit("no wgc nodes specified")
 			}
+			if wgcOutput != "json" && wgcOutput != "yaml" {
+				exit("unknown output format, expected json or yaml", "output", wgcOutput)
+			}
 
-			client := clientConfig()
+			// resolve the consuming cluster before creating any peers on this one
+			var target client.Client
+			if wgcTarget.kubeconfig != "" || wgcTarget.context != "" {
+				var namespace string
+				var err error
+				target, namespace, err = wgcTarget.client()
+				if err != nil {
+					exit("unable to load target kubeconfig", "err", err)
+				}
+				if wgcNamespace == "" {
+					wgcNamespace = namespace
+				}
+			}
+			// the printed secrets and their refs need a namespace, the operator won't guess it
+			if wgcNamespace == "" {
+				exit("--wgc-namespace is required without --target-kubeconfig or --target-context")
+			}
+
+			if wgcEndpointNamespace == "" {
+				wgcEndpointNamespace = clientNamespace()
+			}
+
+			config := clientConfig()
 
 			nodes := make([]v1beta.WireguardClusterClientNode, len(wgcNodes))
+			secrets := make([]corev1.Secret, len(wgcNodes))
 			peers := make([]v1beta.WireguardAccessPeer, len(wgcNodes))
 			group := errgroup.Group{}
 			for i := range wgcNodes {
@@ -94,17 +214,40 @@ func peerCmd() *cobra.Command {
 						exit("unable to generate psk", "err", err)
 					}
 
-					peer, err := NewWGAPeer(ctx, fmt.Sprintf("wgc-%s-%s", args[0], wgcNodes[i]), rules, pk, psk, client)
+					peer, err := newPeerWithPSK(ctx, fmt.Sprintf("wgc-%s-%s", args[0], wgcNodes[i]), v1beta.WireguardAccessPeerSpec{
+						AccessRules: rules,
+						PublicKey:   pk.PublicKey().String(),
+					}, psk, wgcEndpointNamespace, config)
 					if err != nil {
 						return err
 					}
 
+					// the keys go into a secret next to the cluster client, not into its spec
+					ref := &corev1.SecretReference{
+						Name:      fmt.Sprintf("wgc-%s-%s", args[0], wgcNodes[i]),
+						Namespace: wgcNamespace,
+					}
+
 					peers[i] = *peer
+					secrets[i] = corev1.Secret{
+						TypeMeta: v1.TypeMeta{
+							Kind:       "Secret",
+							APIVersion: "v1",
+						},
+						ObjectMeta: v1.ObjectMeta{
+							Name:      ref.Name,
+							Namespace: ref.Namespace,
+						},
+						StringData: map[string]string{
+							operator.SecretKeyName:         pk.String(),
+							operator.SecretKeyPreSharedKey: psk.String(),
+						},
+					}
 					nodes[i] = v1beta.WireguardClusterClientNode{
-						NodeName:     wgcNodes[i],
-						PreSharedKey: psk.String(),
+						NodeName:              wgcNodes[i],
+						PreSharedKeySecretRef: ref,
 						PrivateKey: v1beta.WireguardClusterClientNodePrivateKey{
-							Value: ptr(pk.String()),
+							SecretRef: ref,
 						},
 						Address: strings.Join(peer.Status.Addresses, ","),
 					}
@@ -118,15 +261,10 @@ func peerCmd() *cobra.Command {
 			}
 
 			p := peers[0].Status.Peers[0]
-
-			enc := json.NewEncoder(os.Stdout)
-			enc.SetIndent("", "  ")
-
-			// can't marshal yaml because k8s doesn't have proper yaml field tags
-			enc.Encode(v1beta.WireguardClusterClient{
+			wgcObj := &v1beta.WireguardClusterClient{
 				TypeMeta: v1.TypeMeta{
 					Kind:       "WireguardClusterClient",
-					APIVersion: "wga.kraudcloud.com/v1beta",
+					APIVersion: v1beta.GroupVersion.String(),
 				},
 				ObjectMeta: v1.ObjectMeta{
 					Name: args[0],
@@ -140,17 +278,577 @@ func peerCmd() *cobra.Command {
 					},
 					PersistentKeepalive: 60,
 				},
-			})
+			}
+
+			if target != nil {
+				for i := range secrets {
+					sk := &secrets[i]
+					data := sk.StringData
+					res, err := controllerutil.CreateOrUpdate(ctx, target, sk, func() error {
+						sk.StringData = data
+						return nil
+					})
+					if err != nil {
+						exit("unable to apply secret", "name", sk.Name, "namespace", sk.Namespace, "err", err)
+					}
+					fmt.Printf("secret/%s %s\n", sk.Name, res)
+				}
+
+				spec := wgcObj.Spec
+				res, err := controllerutil.CreateOrUpdate(ctx, target, wgcObj, func() error {
+					wgcObj.Spec = spec
+					return nil
+				})
+				if err != nil {
+					exit("unable to apply WireguardClusterClient", "name", wgcObj.Name, "err", err)
+				}
+				fmt.Printf("wireguardclusterclient/%s %s\n", wgcObj.Name, res)
+				return
+			}
+
+			items := []any{}
+			for _, sk := range secrets {
+				items = append(items, sk)
+			}
+			items = append(items, wgcObj)
+
+			list := map[string]any{
+				"apiVersion": "v1",
+				"kind":       "List",
+				"items":      items,
+			}
+
+			var out []byte
+			switch wgcOutput {
+			case "json":
+				out, err = json.MarshalIndent(list, "", "  ")
+				out = append(out, '\n')
+			case "yaml":
+				out, err = yaml.Marshal(list)
+			}
+			if err != nil {
+				exit("unable to encode WireguardClusterClient", "err", err)
+			}
+			os.Stdout.Write(out)
 		},
 	}
 
 	wgc.Flags().StringSliceVarP(&wgcNodes, "nodes", "n", wgcNodes, "list of WireguardClusterClient node names to connect to")
+	wgc.Flags().StringVar(&wgcNamespace, "wgc-namespace", wgcNamespace, "namespace the WireguardClusterClient runs in, where its key secrets are created. Required without a target, defaults to the target context's namespace")
+	wgc.Flags().StringVar(&wgcEndpointNamespace, "endpoint-namespace", wgcEndpointNamespace, "namespace the endpoint runs in, where the preshared key secrets of the peers are created. Defaults to the kubeconfig's namespace")
+	wgc.Flags().StringVarP(&wgcOutput, "output", "o", wgcOutput, "output format, json or yaml")
+	wgc.Flags().StringVar(&wgcTarget.kubeconfig, "target-kubeconfig", "", "kubeconfig of the cluster running the WireguardClusterClient, to create or update it there instead of printing it")
+	wgc.Flags().StringVar(&wgcTarget.context, "target-context", "", "context in the target kubeconfig")
 	cmd.AddCommand(wgc)
 
+	output := "table"
+	selector := ""
+	listRules := []string{}
+	states := []string{}
+	list := &cobra.Command{
+		Use:     "list",
+		Short:   "list WireguardAccessPeers with their connection status",
+		Aliases: []string{"ls"},
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+
+			sel, err := labels.Parse(selector)
+			if err != nil {
+				exit("invalid selector", "selector", selector, "err", err)
+			}
+
+			c, err := client.New(clientConfig(), client.Options{})
+			if err != nil {
+				exit("unable to create client", "err", err)
+			}
+
+			peers := &v1beta.WireguardAccessPeerList{}
+			err = c.List(ctx, peers, client.MatchingLabelsSelector{Selector: sel})
+			if err != nil {
+				exit("unable to list peers", "err", err)
+			}
+
+			peers.Items = filterPeers(peers.Items, listRules, states, time.Now())
+
+			err = printPeers(os.Stdout, peers, output, time.Now())
+			if err != nil {
+				exit("unable to print peers", "err", err)
+			}
+		},
+	}
+	list.Flags().StringVarP(&output, "output", "o", output, "output format, one of table, json or yaml")
+	list.Flags().StringVarP(&selector, "selector", "l", selector, "label selector to filter peers by, eg. team=ops")
+	list.Flags().StringSliceVarP(&listRules, "rules", "r", listRules, "only list peers with any of these rules")
+	list.Flags().StringSliceVar(&states, "state", states, "only list peers in any of these states: active, idle, never or pending")
+	cmd.AddCommand(list)
+
+	del := &peerSelection{wait: true, timeout: time.Minute}
+	deleteCmd := &cobra.Command{
+		Use:     "delete [name...]",
+		Short:   "delete WireguardAccessPeers and wait until the endpoint removed them",
+		Aliases: []string{"rm"},
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := context.WithTimeout(context.Background(), del.timeout)
+			defer cancel()
+
+			c, peers := del.peers(ctx, args, "delete")
+			for _, peer := range peers {
+				err := c.Delete(ctx, &peer)
+				if client.IgnoreNotFound(err) != nil {
+					exit("unable to delete peer", "peer", peer.Name, "err", err)
+				}
+			}
+
+			for _, peer := range peers {
+				if del.wait {
+					err := waitPeer(ctx, c, peer.Name, func(p *v1beta.WireguardAccessPeer) bool { return p == nil })
+					if err != nil {
+						exit("peer not removed from the endpoint", "peer", peer.Name, "err", err)
+					}
+				}
+
+				fmt.Printf("deleted %s\n", peer.Name)
+			}
+		},
+	}
+	del.flags(deleteCmd)
+	cmd.AddCommand(deleteCmd)
+
+	keyFile := ""
+	endpointNamespace := ""
+	configOut := &configOutput{}
+	config := &cobra.Command{
+		Use:   "config [name]",
+		Short: "print the current wg-quick config of an existing WireguardAccessPeer",
+		Long: "print the current wg-quick config of an existing WireguardAccessPeer, with the endpoints and allowed ips from its status.\n" +
+			"The private key is read from --private-key-file, or from the peer's secret if the endpoint generated it.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+
+			c, err := client.New(clientConfig(), client.Options{})
+			if err != nil {
+				exit("unable to create client", "err", err)
+			}
+
+			peer := &v1beta.WireguardAccessPeer{}
+			err = c.Get(ctx, client.ObjectKey{Name: args[0]}, peer)
+			if err != nil {
+				exit("unable to get peer", "err", err)
+			}
+
+			if endpointNamespace == "" {
+				endpointNamespace = clientNamespace()
+			}
+
+			var pk wgtypes.Key
+			switch {
+			case keyFile != "":
+				pk, err = readKeyFile(keyFile)
+			case peer.Spec.PrivateKeySecretRef != nil:
+				pk, err = peerPrivateKey(ctx, c, *peer.Spec.PrivateKeySecretRef, endpointNamespace)
+			default:
+				exit("the private key of the peer is not kept by the endpoint, pass --private-key-file")
+			}
+			if err != nil {
+				exit("unable to read private key", "err", err)
+			}
+
+			if pk.PublicKey().String() != peer.Spec.PublicKey {
+				exit("private key doesn't belong to the peer", "publicKey", peer.Spec.PublicKey)
+			}
+
+			cfg, err := operator.PeerConfig(ctx, c, peer, pk, endpointNamespace)
+			if err != nil {
+				exit("unable to render config", "err", err)
+			}
+
+			err = configOut.print(cfg)
+			if err != nil {
+				exit("unable to print peer config", "err", err)
+			}
+		},
+	}
+	config.Flags().StringVar(&keyFile, "private-key-file", keyFile, "file holding the private key of the peer, - for stdin")
+	config.Flags().StringVar(&endpointNamespace, "endpoint-namespace", endpointNamespace, "namespace the endpoint runs in, secret references without namespace point there. Defaults to the kubeconfig's namespace")
+	configOut.flags(config)
+	cmd.AddCommand(config)
+
+	for _, disabled := range []bool{true, false} {
+		verb := "enable"
+		short := "re-enable disabled WireguardAccessPeers and wait until the endpoint configured them"
+		if disabled {
+			verb = "disable"
+			short = "remove WireguardAccessPeers from the endpoint but keep their address and config"
+		}
+
+		sel := &peerSelection{wait: true, timeout: time.Minute}
+		toggle := &cobra.Command{
+			Use:   verb + " [name...]",
+			Short: short,
+			Run: func(cmd *cobra.Command, args []string) {
+				ctx, cancel := context.WithTimeout(context.Background(), sel.timeout)
+				defer cancel()
+
+				c, peers := sel.peers(ctx, args, verb)
+				for i, peer := range peers {
+					patch := client.MergeFrom(peer.DeepCopy())
+					peer.Spec.Disabled = disabled
+					err := c.Patch(ctx, &peer, patch)
+					if err != nil {
+						exit("unable to "+verb+" peer", "peer", peer.Name, "err", err)
+					}
+					peers[i] = peer
+				}
+
+				for _, peer := range peers {
+					if sel.wait {
+						err := waitPeer(ctx, c, peer.Name, func(p *v1beta.WireguardAccessPeer) bool { return p == nil || peerSynced(p, peer.Generation) })
+						if err != nil {
+							exit("peer not "+verb+"d by the endpoint", "peer", peer.Name, "err", err)
+						}
+					}
+
+					fmt.Printf("%sd %s\n", verb, peer.Name)
+				}
+			},
+		}
+		sel.flags(toggle)
+		cmd.AddCommand(toggle)
+	}
+
 	return cmd
 }
 
-func NewWGAPeer(ctx context.Context, name string, rules []string, keyset, pskset wgtypes.Key, config *rest.Config) (*v1beta.WireguardAccessPeer, error) {
+// targetCluster is a cluster other than the one wga runs in, eg. the one consuming a WireguardClusterClient.
+type targetCluster struct {
+	kubeconfig string
+	context    string
+}
+
+// client returns a client for the target cluster and the namespace of its context.
+// Without a kubeconfig, the default loading rules apply, so --target-context alone picks a context from $KUBECONFIG or ~/.kube/config.
+func (t targetCluster) client() (client.Client, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if t.kubeconfig != "" {
+		rules.ExplicitPath = t.kubeconfig
+	}
+
+	cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: t.context})
+	config, err := cc.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	namespace, _, err := cc.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c, err := client.New(config, client.Options{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return c, namespace, nil
+}
+
+// readKeyFile reads a wireguard key as written by wg genkey, - reads stdin.
+func readKeyFile(path string) (wgtypes.Key, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	return wgtypes.ParseKey(strings.TrimSpace(string(data)))
+}
+
+// peerPrivateKey reads the private key the endpoint generated for a peer from its secret.
+func peerPrivateKey(ctx context.Context, c client.Client, ref corev1.SecretReference, namespace string) (wgtypes.Key, error) {
+	if ref.Namespace == "" {
+		ref.Namespace = namespace
+	}
+
+	sk := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, sk)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("cannot get secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	return wgtypes.ParseKey(string(sk.Data[operator.SecretKeyName]))
+}
+
+// peerSelection picks the peers a command acts on, by name or by label selector.
+type peerSelection struct {
+	selector string
+	yes      bool
+	wait     bool
+	timeout  time.Duration
+}
+
+func (s *peerSelection) flags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&s.selector, "selector", "l", s.selector, "label selector to pick peers by instead of names, eg. team=ops")
+	cmd.Flags().BoolVarP(&s.yes, "yes", "y", s.yes, "don't ask for confirmation when several peers match")
+	cmd.Flags().BoolVar(&s.wait, "wait", s.wait, "wait until the endpoint applied the change")
+	cmd.Flags().DurationVar(&s.timeout, "timeout", s.timeout, "how long to wait for the endpoint")
+}
+
+// peers returns the selected peers, after confirmation if there are several. It exits on errors.
+func (s *peerSelection) peers(ctx context.Context, names []string, verb string) (client.Client, []v1beta.WireguardAccessPeer) {
+	if (len(names) > 0) == (s.selector != "") {
+		exit("pass either peer names or a selector")
+	}
+
+	sel, err := labels.Parse(s.selector)
+	if err != nil {
+		exit("invalid selector", "selector", s.selector, "err", err)
+	}
+
+	c, err := client.New(clientConfig(), client.Options{})
+	if err != nil {
+		exit("unable to create client", "err", err)
+	}
+
+	peers := []v1beta.WireguardAccessPeer{}
+	if s.selector != "" {
+		list := &v1beta.WireguardAccessPeerList{}
+		err = c.List(ctx, list, client.MatchingLabelsSelector{Selector: sel})
+		if err != nil {
+			exit("unable to list peers", "err", err)
+		}
+		peers = list.Items
+	}
+
+	for _, name := range names {
+		peer := v1beta.WireguardAccessPeer{}
+		err = c.Get(ctx, client.ObjectKey{Name: name}, &peer)
+		if err != nil {
+			exit("unable to get peer", "peer", name, "err", err)
+		}
+		peers = append(peers, peer)
+	}
+
+	if len(peers) == 0 {
+		exit("no peers match", "selector", s.selector)
+	}
+
+	if len(peers) > 1 && !s.yes && !confirm(os.Stdin, os.Stderr, verb, peers) {
+		exit("aborted")
+	}
+
+	return c, peers
+}
+
+// confirm asks whether to act on several peers, anything but yes means no.
+func confirm(in io.Reader, out io.Writer, verb string, peers []v1beta.WireguardAccessPeer) bool {
+	names := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		names = append(names, peer.Name)
+	}
+
+	fmt.Fprintf(out, "%s %d peers: %s? [y/N] ", verb, len(peers), strings.Join(names, ", "))
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// peerSynced reports whether the endpoint applied the given generation of the peer to the device.
+func peerSynced(peer *v1beta.WireguardAccessPeer, generation int64) bool {
+	if peer.Status == nil || peer.Status.ObservedGeneration < generation {
+		return false
+	}
+
+	cond := meta.FindStatusCondition(peer.Status.Conditions, v1beta.ConditionDataplaneSynced)
+	return cond != nil && cond.Status == v1.ConditionTrue && cond.ObservedGeneration >= generation
+}
+
+// waitPeer polls the peer until done reports true. done gets nil once the peer is deleted.
+func waitPeer(ctx context.Context, c client.Client, name string, done func(*v1beta.WireguardAccessPeer) bool) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		peer := &v1beta.WireguardAccessPeer{}
+		err := c.Get(ctx, client.ObjectKey{Name: name}, peer)
+		if apierrors.IsNotFound(err) {
+			peer = nil
+		} else if err != nil {
+			return err
+		}
+
+		if done(peer) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for the endpoint: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// filterPeers keeps the peers having any of the rules and being in any of the states, an empty filter keeps all.
+func filterPeers(peers []v1beta.WireguardAccessPeer, rules []string, states []string, now time.Time) []v1beta.WireguardAccessPeer {
+	return slices.DeleteFunc(peers, func(peer v1beta.WireguardAccessPeer) bool {
+		if len(rules) > 0 && !slices.ContainsFunc(peer.Spec.AccessRules, func(r string) bool { return slices.Contains(rules, r) }) {
+			return true
+		}
+
+		return len(states) > 0 && !slices.Contains(states, operator.PeerState(&peer, now))
+	})
+}
+
+// printPeers writes the peers as a table, or as a kubectl compatible list in json or yaml.
+func printPeers(w io.Writer, peers *v1beta.WireguardAccessPeerList, output string, now time.Time) error {
+	peers.TypeMeta = v1.TypeMeta{Kind: "List", APIVersion: "v1"}
+	for i := range peers.Items {
+		peers.Items[i].TypeMeta = v1.TypeMeta{Kind: "WireguardAccessPeer", APIVersion: "wga.kraudcloud.com/v1beta"}
+	}
+
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(peers)
+	case "yaml":
+		out, err := yaml.Marshal(peers)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case "table", "":
+	default:
+		return fmt.Errorf("unknown output format %q", output)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESSES\tRULES\tSTATE\tLAST HANDSHAKE\tRX\tTX\tAGE")
+
+	for _, peer := range peers.Items {
+		addrs, handshake, rx, tx := "<pending>", "<never>", "-", "-"
+		if peer.Status != nil {
+			addrs = strings.Join(peer.Status.Addresses, ",")
+
+			last := time.Time{}
+			if c := peer.Status.Connection; c != nil {
+				rx, tx = formatBytes(c.ReceiveBytes), formatBytes(c.TransmitBytes)
+				if c.LastHandshake != nil {
+					last = c.LastHandshake.Time
+				}
+			}
+			if seen := peer.Status.LastSeen; seen != nil && seen.After(last) {
+				last = seen.Time
+			}
+			if !last.IsZero() {
+				handshake = duration.HumanDuration(now.Sub(last)) + " ago"
+			}
+		}
+
+		rules := strings.Join(peer.Spec.AccessRules, ",")
+		if rules == "" {
+			rules = "<none>"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			peer.Name,
+			addrs,
+			rules,
+			operator.PeerState(&peer, now),
+			handshake,
+			rx,
+			tx,
+			duration.HumanDuration(now.Sub(peer.CreationTimestamp.Time)),
+		)
+	}
+
+	return tw.Flush()
+}
+
+// formatBytes formats a byte count with binary units, eg. 1.5MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// newPeerWithPSK creates a peer whose preshared key is kept in a secret in the endpoint's namespace,
+// so the key never shows up in the peer, etcd's copy of it, watch events or audit logs.
+// The secret is created first and owned by the peer once it exists. A zero psk creates the peer without one.
+func newPeerWithPSK(ctx context.Context, name string, spec v1beta.WireguardAccessPeerSpec, psk wgtypes.Key, namespace string, config *rest.Config) (*v1beta.WireguardAccessPeer, error) {
+	if psk == (wgtypes.Key{}) {
+		return NewWGAPeer(ctx, name, spec, config)
+	}
+
+	c, err := client.New(config, client.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create client: %w", err)
+	}
+
+	sk := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("wgap-%s", name),
+			Namespace: namespace,
+			Labels:    map[string]string{operator.LabelPeer: name},
+		},
+		Data: map[string][]byte{
+			operator.SecretKeyPreSharedKey: []byte(psk.String()),
+		},
+	}
+	err = c.Create(ctx, sk)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create preshared key secret: %w", err)
+	}
+
+	spec.PreSharedKey = ""
+	spec.PreSharedKeySecretRef = &corev1.SecretReference{Name: sk.Name, Namespace: sk.Namespace}
+
+	peer, err := NewWGAPeer(ctx, name, spec, config)
+	if err != nil {
+		// ctx may be done already. A peer that was created without getting a status still needs its key
+		cleanup := context.Background()
+		if getErr := c.Get(cleanup, client.ObjectKey{Name: name}, &v1beta.WireguardAccessPeer{}); apierrors.IsNotFound(getErr) {
+			if delErr := c.Delete(cleanup, sk); delErr != nil && !apierrors.IsNotFound(delErr) {
+				slog.Warn("cannot clean up preshared key secret", "secret", sk.Namespace+"/"+sk.Name, "err", delErr)
+			}
+		}
+		return nil, err
+	}
+
+	patch := client.MergeFrom(sk.DeepCopy())
+	err = controllerutil.SetOwnerReference(peer, sk, c.Scheme())
+	if err == nil {
+		err = c.Patch(ctx, sk, patch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot make peer own its preshared key secret: %w", err)
+	}
+
+	return peer, nil
+}
+
+func NewWGAPeer(ctx context.Context, name string, spec v1beta.WireguardAccessPeerSpec, config *rest.Config) (*v1beta.WireguardAccessPeer, error) {
 	peerValue := v1beta.WireguardAccessPeer{
 		ObjectMeta: v1.ObjectMeta{
 			Name: name,
@@ -159,11 +857,7 @@ func NewWGAPeer(ctx context.Context, name string, rules []string, keyset, pskset
 			Kind:       "WireguardAccessPeer",
 			APIVersion: "wga.kraudcloud.com/v1beta",
 		},
-		Spec: v1beta.WireguardAccessPeerSpec{
-			AccessRules:  rules,
-			PublicKey:    keyset.PublicKey().String(),
-			PreSharedKey: pskset.String(),
-		},
+		Spec: spec,
 	}
 
 	c, err := client.NewWithWatch(config, client.Options{})
@@ -207,78 +901,146 @@ func NewWGAPeer(ctx context.Context, name string, rules []string, keyset, pskset
 	return populatedPeer, nil
 }
 
-func FormatPeerIni(peer v1beta.WireguardAccessPeer, dns []string, pk, psk wgtypes.Key) error {
-	peers := []wgtypes.Peer{}
-	for _, peer := range peer.Status.Peers {
-		publicKey, err := wgtypes.ParseKey(peer.PublicKey)
-		if err != nil {
-			return fmt.Errorf("cannot parse public key: %w", err)
+// peerSecretConfig waits for the endpoint to render the config of a peer with a generated key into its secret,
+// and builds it again from the private key next to it.
+func peerSecretConfig(ctx context.Context, peer v1beta.WireguardAccessPeer, namespace string, config *rest.Config) (clientconfig.ConfigFile, error) {
+	if peer.Spec.PrivateKeySecretRef == nil {
+		return clientconfig.ConfigFile{}, fmt.Errorf("peer has no private key secret")
+	}
+	ref := *peer.Spec.PrivateKeySecretRef
+	if ref.Namespace == "" {
+		ref.Namespace = namespace
+	}
+
+	c, err := client.New(config, client.Options{})
+	if err != nil {
+		return clientconfig.ConfigFile{}, fmt.Errorf("cannot create client: %w", err)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		sk := &corev1.Secret{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, sk)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return clientconfig.ConfigFile{}, fmt.Errorf("cannot get secret %s/%s: %w", ref.Namespace, ref.Name, err)
 		}
 
-		ips := []net.IPNet{}
-		for _, ip := range peer.AllowedIPs {
-			_, cidr, err := net.ParseCIDR(ip)
+		// once the config is rendered the preshared key is in place too
+		if len(sk.Data[operator.SecretKeyConfig]) > 0 {
+			pk, err := wgtypes.ParseKey(string(sk.Data[operator.SecretKeyName]))
 			if err != nil {
-				return fmt.Errorf("cannot parse allowed ip: %w", err)
+				return clientconfig.ConfigFile{}, fmt.Errorf("cannot parse private key in secret %s/%s: %w", ref.Namespace, ref.Name, err)
 			}
 
-			ips = append(ips, *cidr)
-		}
+			err = c.Get(ctx, client.ObjectKeyFromObject(&peer), &peer)
+			if err != nil {
+				return clientconfig.ConfigFile{}, fmt.Errorf("cannot get peer: %w", err)
+			}
 
-		endpoint, err := netip.ParseAddrPort(peer.Endpoint)
-		if err != nil {
-			return fmt.Errorf("cannot parse endpoint: %w", err)
+			return operator.PeerConfig(ctx, c, &peer, pk, namespace)
 		}
 
-		if len(peer.PreSharedKey) != 0 {
-			psk, err = wgtypes.ParseKey(peer.PreSharedKey)
-			if err != nil {
-				return fmt.Errorf("cannot parse preshared key: %w", err)
-			}
+		select {
+		case <-ctx.Done():
+			return clientconfig.ConfigFile{}, fmt.Errorf("secret %s/%s has no config: %w", ref.Namespace, ref.Name, ctx.Err())
+		case <-ticker.C:
 		}
+	}
+}
+
+// configOutput prints a client config in one of the clientconfig formats, or as a qr code for the mobile apps.
+type configOutput struct {
+	format string
+	dir    string
+	qr     bool
+	png    string
+}
+
+func (o *configOutput) flags(cmd *cobra.Command) {
+	o.format = clientconfig.FormatWGQuick
+	cmd.Flags().StringVarP(&o.format, "output", "o", o.format, "config format, one of "+strings.Join(clientconfig.Formats(), ", "))
+	cmd.Flags().StringVar(&o.dir, "output-dir", o.dir, "write the config files into this directory instead of printing them")
+	cmd.Flags().BoolVar(&o.qr, "qr", o.qr, "print the wg-quick config as a qr code to scan with the wireguard app")
+	cmd.Flags().StringVar(&o.png, "qr-png", o.png, "also write the wg-quick config as a qr code to this png file")
+}
 
-		peers = append(peers, wgtypes.Peer{
-			PublicKey:                   publicKey,
-			AllowedIPs:                  ips,
-			Endpoint:                    net.UDPAddrFromAddrPort(endpoint),
-			PresharedKey:                psk,
-			PersistentKeepaliveInterval: time.Second * 60,
-		})
+func (o *configOutput) print(cfg clientconfig.ConfigFile) error {
+	if (o.qr || o.png != "") && o.format != clientconfig.FormatWGQuick {
+		return fmt.Errorf("qr codes are only made of %s configs", clientconfig.FormatWGQuick)
 	}
 
-	ips := ""
-	if len(peer.Status.Addresses) == 0 {
-		peer.Status.Addresses = []string{peer.Status.Address}
+	files, err := clientconfig.RenderFiles(cfg, o.format)
+	if err != nil {
+		return err
 	}
-	for _, ip := range peer.Status.Addresses {
-		ip := net.ParseIP(ip)
-		if len(ips) > 0 {
-			ips += ","
+
+	for _, f := range files {
+		if o.dir == "" {
+			break
 		}
-		ipn := net.IPNet{
-			IP:   ip,
-			Mask: operator.FullMask(ip),
+
+		// they hold the private key
+		path := filepath.Join(o.dir, f.Name)
+		err = os.WriteFile(path, f.Data, 0o600)
+		if err != nil {
+			return fmt.Errorf("cannot write config: %w", err)
 		}
-		ips += ipn.String()
-	}
-
-	oubuf := &strings.Builder{}
-	err := Format(oubuf, ConfigFile{
-		Name:    peer.Name,
-		Address: ips,
-		DNS:     dns,
-		Device: wgtypes.Device{
-			Name:       peer.Name,
-			PrivateKey: pk,
-			ListenPort: 51820,
-			Peers:      peers,
-		},
-	})
+		fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+	}
+
+	if o.qr || o.png != "" {
+		return o.printQR(string(files[0].Data))
+	}
+
+	if o.dir != "" {
+		return nil
+	}
+
+	if len(files) == 1 {
+		fmt.Printf("%s\n", files[0].Data)
+		return nil
+	}
+
+	for i, f := range files {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("# %s\n%s", f.Name, f.Data)
+	}
+
+	return nil
+}
+
+func (o *configOutput) printQR(ini string) error {
+	q, err := qrcode.New(ini, qrcode.Medium)
 	if err != nil {
-		return fmt.Errorf("Format: %w", err)
+		return fmt.Errorf("cannot encode qr code: %w", err)
+	}
+
+	if o.png != "" {
+		png, err := q.PNG(512)
+		if err != nil {
+			return fmt.Errorf("cannot encode qr code: %w", err)
+		}
+
+		// it holds the private key
+		err = os.WriteFile(o.png, png, 0o600)
+		if err != nil {
+			return fmt.Errorf("cannot write qr code: %w", err)
+		}
+	}
+
+	if !o.qr {
+		if o.dir == "" {
+			fmt.Printf("%s\n", ini)
+		}
+		return nil
 	}
 
-	fmt.Printf("%s\n", oubuf.String())
+	// light modules as blocks, for terminals with a dark background
+	fmt.Print(q.ToSmallString(false))
 	return nil
 }
 
@@ -6,7 +6,8 @@
 package v1beta
 
 import (
-	v1 "k8s.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -83,6 +84,25 @@ func (in *WireguardAccessPeerSpec) DeepCopyInto(out *WireguardAccessPeerSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.PreSharedKeySecretRef != nil {
+		in, out := &in.PreSharedKeySecretRef, &out.PreSharedKeySecretRef
+		*out = new(corev1.SecretReference)
+		**out = **in
+	}
+	if in.PreSharedKeyRotationInterval != nil {
+		in, out := &in.PreSharedKeyRotationInterval, &out.PreSharedKeyRotationInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.PrivateKeySecretRef != nil {
+		in, out := &in.PrivateKeySecretRef, &out.PrivateKeySecretRef
+		*out = new(corev1.SecretReference)
+		**out = **in
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
 	return
 }
 
@@ -117,6 +137,26 @@ func (in *WireguardAccessPeerStatus) DeepCopyInto(out *WireguardAccessPeerStatus
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Connection != nil {
+		in, out := &in.Connection, &out.Connection
+		*out = new(WireguardAccessPeerStatusConnection)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastSeen != nil {
+		in, out := &in.LastSeen, &out.LastSeen
+		*out = (*in).DeepCopy()
+	}
+	if in.PreSharedKeyRotatedAt != nil {
+		in, out := &in.PreSharedKeyRotatedAt, &out.PreSharedKeyRotatedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -130,9 +170,35 @@ func (in *WireguardAccessPeerStatus) DeepCopy() *WireguardAccessPeerStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardAccessPeerStatusConnection) DeepCopyInto(out *WireguardAccessPeerStatusConnection) {
+	*out = *in
+	if in.LastHandshake != nil {
+		in, out := &in.LastHandshake, &out.LastHandshake
+		*out = (*in).DeepCopy()
+	}
+	in.LastUpdated.DeepCopyInto(&out.LastUpdated)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardAccessPeerStatusConnection.
+func (in *WireguardAccessPeerStatusConnection) DeepCopy() *WireguardAccessPeerStatusConnection {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardAccessPeerStatusConnection)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardAccessPeerStatusPeer) DeepCopyInto(out *WireguardAccessPeerStatusPeer) {
 	*out = *in
+	if in.PreSharedKeySecretRef != nil {
+		in, out := &in.PreSharedKeySecretRef, &out.PreSharedKeySecretRef
+		*out = new(corev1.SecretReference)
+		**out = **in
+	}
 	if in.AllowedIPs != nil {
 		in, out := &in.AllowedIPs, &out.AllowedIPs
 		*out = make([]string, len(*in))
@@ -151,12 +217,93 @@ func (in *WireguardAccessPeerStatusPeer) DeepCopy() *WireguardAccessPeerStatusPe
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardAccessRevocation) DeepCopyInto(out *WireguardAccessRevocation) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardAccessRevocation.
+func (in *WireguardAccessRevocation) DeepCopy() *WireguardAccessRevocation {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardAccessRevocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WireguardAccessRevocation) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardAccessRevocationList) DeepCopyInto(out *WireguardAccessRevocationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WireguardAccessRevocation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardAccessRevocationList.
+func (in *WireguardAccessRevocationList) DeepCopy() *WireguardAccessRevocationList {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardAccessRevocationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WireguardAccessRevocationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardAccessRevocationSpec) DeepCopyInto(out *WireguardAccessRevocationSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardAccessRevocationSpec.
+func (in *WireguardAccessRevocationSpec) DeepCopy() *WireguardAccessRevocationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardAccessRevocationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardAccessRule) DeepCopyInto(out *WireguardAccessRule) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	if in.Status != nil {
+		in, out := &in.Status, &out.Status
+		*out = new(WireguardAccessRuleStatus)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -232,6 +379,29 @@ func (in *WireguardAccessRuleSpec) DeepCopy() *WireguardAccessRuleSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WireguardAccessRuleStatus) DeepCopyInto(out *WireguardAccessRuleStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WireguardAccessRuleStatus.
+func (in *WireguardAccessRuleStatus) DeepCopy() *WireguardAccessRuleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WireguardAccessRuleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardClusterClient) DeepCopyInto(out *WireguardClusterClient) {
 	*out = *in
@@ -300,6 +470,11 @@ func (in *WireguardClusterClientList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardClusterClientNode) DeepCopyInto(out *WireguardClusterClientNode) {
 	*out = *in
+	if in.PreSharedKeySecretRef != nil {
+		in, out := &in.PreSharedKeySecretRef, &out.PreSharedKeySecretRef
+		*out = new(corev1.SecretReference)
+		**out = **in
+	}
 	in.PrivateKey.DeepCopyInto(&out.PrivateKey)
 	return
 }
@@ -324,7 +499,7 @@ func (in *WireguardClusterClientNodePrivateKey) DeepCopyInto(out *WireguardClust
 	}
 	if in.SecretRef != nil {
 		in, out := &in.SecretRef, &out.SecretRef
-		*out = new(v1.SecretReference)
+		*out = new(corev1.SecretReference)
 		**out = **in
 	}
 	return
@@ -391,7 +566,16 @@ func (in *WireguardClusterClientStatus) DeepCopyInto(out *WireguardClusterClient
 	if in.Nodes != nil {
 		in, out := &in.Nodes, &out.Nodes
 		*out = make([]WireguardClusterClientStatusNode, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	return
 }
@@ -409,6 +593,13 @@ func (in *WireguardClusterClientStatus) DeepCopy() *WireguardClusterClientStatus
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WireguardClusterClientStatusNode) DeepCopyInto(out *WireguardClusterClientStatusNode) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -49,6 +49,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&WireguardAccessPeerList{},
 		&WireguardAccessRule{},
 		&WireguardAccessRuleList{},
+		&WireguardAccessRevocation{},
+		&WireguardAccessRevocationList{},
 		&WireguardClusterClient{},
 		&WireguardClusterClientList{},
 	)
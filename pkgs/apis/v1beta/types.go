@@ -12,12 +12,41 @@ type WireguardAccessRule struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 	Spec              WireguardAccessRuleSpec `json:"spec" yaml:"spec"`
+	//+optional
+	Status *WireguardAccessRuleStatus `json:"status,omitempty" yaml:"status,omitempty"`
 }
 
 type WireguardAccessRuleSpec struct {
 	Destinations []string `yaml:"destinations" json:"destinations"`
 }
 
+type WireguardAccessRuleStatus struct {
+	//+optional
+	ObservedGeneration int64 `yaml:"observedGeneration,omitempty" json:"observedGeneration,omitempty"`
+	//+optional
+	Conditions []metav1.Condition `yaml:"conditions,omitempty" json:"conditions,omitempty"`
+}
+
+// WireguardAccessRevocation records a public key that must never be configured on the endpoint again,
+// eg. the key of a stolen laptop. Peers using it are flagged Revoked.
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type WireguardAccessRevocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              WireguardAccessRevocationSpec `json:"spec" yaml:"spec"`
+}
+
+type WireguardAccessRevocationSpec struct {
+	PublicKey string `yaml:"publicKey" json:"publicKey"`
+	// Peer is the name of the peer the key belonged to, for the record.
+	//+optional
+	Peer string `yaml:"peer,omitempty" json:"peer,omitempty"`
+	//+optional
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
 // +genclient
 // +genclient:nonNamespaced
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -31,10 +60,33 @@ type WireguardAccessPeer struct {
 }
 
 type WireguardAccessPeerSpec struct {
+	// PreSharedKey is moved into the secret referenced by PreSharedKeySecretRef by the endpoint.
+	// Deprecated: use PreSharedKeySecretRef.
+	//+optional
+	PreSharedKey string `yaml:"preSharedKey,omitempty" json:"preSharedKey,omitempty"`
+	// PreSharedKeySecretRef is the secret holding the preshared key under preSharedKey.
+	// The namespace defaults to the endpoint's. Changes to the secret only reach the endpoint
+	// right away if it carries the wga.kraudcloud.com/peer label.
 	//+optional
-	PreSharedKey string   `yaml:"preSharedKey,omitempty" json:"preSharedKey,omitempty"`
-	PublicKey    string   `yaml:"publicKey" json:"publicKey"`
-	AccessRules  []string `yaml:"accessRules" json:"accessRules"`
+	PreSharedKeySecretRef *corev1.SecretReference `yaml:"preSharedKeySecretRef,omitempty" json:"preSharedKeySecretRef,omitempty"`
+	// PreSharedKeyRotationInterval overrides how often the endpoint replaces the preshared key, 0 never rotates.
+	//+optional
+	PreSharedKeyRotationInterval *metav1.Duration `yaml:"preSharedKeyRotationInterval,omitempty" json:"preSharedKeyRotationInterval,omitempty"`
+	// PublicKey of the peer. When empty, the endpoint generates a keypair and stores the private key
+	// and the rendered wg-quick config in the secret referenced by PrivateKeySecretRef.
+	//+optional
+	PublicKey   string   `yaml:"publicKey,omitempty" json:"publicKey,omitempty"`
+	AccessRules []string `yaml:"accessRules" json:"accessRules"`
+	// PrivateKeySecretRef is the secret holding a private key generated by the endpoint.
+	// Defaults to wgap-<name> in the endpoint's namespace.
+	//+optional
+	PrivateKeySecretRef *corev1.SecretReference `yaml:"privateKeySecretRef,omitempty" json:"privateKeySecretRef,omitempty"`
+	// ExpiresAt removes the peer from the endpoint once passed.
+	//+optional
+	ExpiresAt *metav1.Time `yaml:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	// Disabled removes the peer from the endpoint but keeps its address, so it can be re-enabled with the same config.
+	//+optional
+	Disabled bool `yaml:"disabled,omitempty" json:"disabled,omitempty"`
 }
 
 type WireguardAccessPeerStatus struct {
@@ -45,14 +97,62 @@ type WireguardAccessPeerStatus struct {
 	Addresses []string                        `yaml:"addresses" json:"addresses"`
 	DNS       []string                        `yaml:"dns" json:"dns"`
 	Peers     []WireguardAccessPeerStatusPeer `yaml:"peers" json:"peers"`
+	//+optional
+	Connection *WireguardAccessPeerStatusConnection `yaml:"connection,omitempty" json:"connection,omitempty"`
+	// LastSeen is the latest handshake the endpoint has seen from the peer.
+	// Unlike connection.lastHandshake it survives endpoint restarts.
+	//+optional
+	LastSeen *metav1.Time `yaml:"lastSeen,omitempty" json:"lastSeen,omitempty"`
+	// PreSharedKeyRotatedAt is when the endpoint last replaced the preshared key.
+	//+optional
+	PreSharedKeyRotatedAt *metav1.Time `yaml:"preSharedKeyRotatedAt,omitempty" json:"preSharedKeyRotatedAt,omitempty"`
+	//+optional
+	ObservedGeneration int64 `yaml:"observedGeneration,omitempty" json:"observedGeneration,omitempty"`
+	//+optional
+	Conditions []metav1.Condition `yaml:"conditions,omitempty" json:"conditions,omitempty"`
+}
+
+// Condition types of wga objects.
+const (
+	// ConditionReady is true when the object is fully applied.
+	ConditionReady = "Ready"
+	// ConditionAddressAllocated is true once a peer has its addresses.
+	ConditionAddressAllocated = "AddressAllocated"
+	// ConditionDataplaneSynced is true when the wireguard device matches the object.
+	ConditionDataplaneSynced = "DataplaneSynced"
+	// ConditionInvalidSpec is true when the spec can't be applied, eg. because of an unparseable key.
+	ConditionInvalidSpec = "InvalidSpec"
+
+	// ConditionExpired is true once spec.expiresAt has passed and the peer was removed from the endpoint.
+	ConditionExpired = "Expired"
+	// ConditionIdle is true when the peer had no handshake for longer than the endpoint's idle threshold.
+	ConditionIdle = "Idle"
+	// ConditionRevoked is true when the public key of the peer is revoked by a WireguardAccessRevocation.
+	ConditionRevoked = "Revoked"
+)
+
+// WireguardAccessPeerStatusConnection is the session state of the peer as seen
+// by the endpoint's wireguard device.
+type WireguardAccessPeerStatusConnection struct {
+	//+optional
+	LastHandshake *metav1.Time `yaml:"lastHandshake,omitempty" json:"lastHandshake,omitempty"`
+	//+optional
+	Endpoint      string      `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	ReceiveBytes  int64       `yaml:"receiveBytes" json:"receiveBytes"`
+	TransmitBytes int64       `yaml:"transmitBytes" json:"transmitBytes"`
+	LastUpdated   metav1.Time `yaml:"lastUpdated" json:"lastUpdated"`
 }
 
 type WireguardAccessPeerStatusPeer struct {
 	PublicKey string `yaml:"publicKey" json:"publicKey"`
 	Endpoint  string `yaml:"endpoint" json:"endpoint"`
+	// Deprecated: use PreSharedKeySecretRef.
+	//+optional
+	PreSharedKey string `yaml:"preSharedKey,omitempty" json:"preSharedKey,omitempty"`
+	// PreSharedKeySecretRef is the secret holding the preshared key under preSharedKey.
 	//+optional
-	PreSharedKey string   `yaml:"preSharedKey,omitempty" json:"preSharedKey,omitempty"`
-	AllowedIPs   []string `yaml:"allowedIPs" json:"allowedIPs"`
+	PreSharedKeySecretRef *corev1.SecretReference `yaml:"preSharedKeySecretRef,omitempty" json:"preSharedKeySecretRef,omitempty"`
+	AllowedIPs            []string                `yaml:"allowedIPs" json:"allowedIPs"`
 }
 
 // +genclient
@@ -80,8 +180,14 @@ type WireguardClusterClientSpec struct {
 type WireguardClusterClientNode struct {
 	NodeName string `yaml:"nodeName" json:"nodeName"`
 
+	// PreSharedKey is moved into the secret referenced by PreSharedKeySecretRef by the cluster client.
+	// Deprecated: use PreSharedKeySecretRef.
 	//+optional
 	PreSharedKey string `yaml:"preSharedKey,omitempty" json:"preSharedKey,omitempty"`
+	// PreSharedKeySecretRef is the secret holding the preshared key under preSharedKey.
+	// The namespace defaults to the cluster client's.
+	//+optional
+	PreSharedKeySecretRef *corev1.SecretReference `yaml:"preSharedKeySecretRef,omitempty" json:"preSharedKeySecretRef,omitempty"`
 
 	PrivateKey WireguardClusterClientNodePrivateKey `yaml:"privateKey" json:"privateKey"`
 
@@ -98,11 +204,18 @@ type WireguardClusterClientNodePrivateKey struct {
 
 type WireguardClusterClientStatus struct {
 	Nodes []WireguardClusterClientStatusNode `yaml:"nodes" json:"nodes"`
+	//+optional
+	ObservedGeneration int64 `yaml:"observedGeneration,omitempty" json:"observedGeneration,omitempty"`
+	//+optional
+	Conditions []metav1.Condition `yaml:"conditions,omitempty" json:"conditions,omitempty"`
 }
 
 type WireguardClusterClientStatusNode struct {
 	PublicKey string `yaml:"publicKey" json:"publicKey"`
 	NodeName  string `yaml:"nodeName" json:"nodeName"`
+	// Conditions reported by the cluster client on this node.
+	//+optional
+	Conditions []metav1.Condition `yaml:"conditions,omitempty" json:"conditions,omitempty"`
 }
 
 type WireguardClusterClientSpecServer struct {
@@ -130,6 +243,15 @@ type WireguardAccessRuleList struct {
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
+type WireguardAccessRevocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	//+optional
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WireguardAccessRevocation `json:"items" yaml:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
 type WireguardClusterClientList struct {
 	metav1.TypeMeta `json:",inline"`
 	//+optional
@@ -1,4 +1,4 @@
-package main
+package clientconfig
 
 import (
 	"io"
@@ -14,7 +14,13 @@ const WgFile = `{{- if .Name -}}
 # {{.Name }}
 {{- end }}
 [Interface]
+{{- if validKey .PrivateKey }}
 PrivateKey = {{ .PrivateKey }}
+{{- else if .PrivateKeyFile }}
+PostUp = wg set %i private-key {{ .PrivateKeyFile }}
+{{- else }}
+PrivateKey = {{ placeholder }}
+{{- end }}
 Address = {{ .Address }}
 DNS = {{ join .DNS ", " }}
 
@@ -51,15 +57,33 @@ var funcs = template.FuncMap{
 	"seconds": func(d time.Duration) int {
 		return int(d.Seconds())
 	},
+	"placeholder": func() string {
+		return PrivateKeyPlaceholder
+	},
 }
 
 var wgFileTemplate = template.Must(template.New("wg-file").Funcs(funcs).Parse(WgFile))
 
+// PrivateKeyPlaceholder stands in for the private key of configs rendered without one,
+// eg. when the key never left the client device.
+const PrivateKeyPlaceholder = "<private key>"
+
 type ConfigFile struct {
 	Address string
 	DNS     []string
 	wgtypes.Device
 	Name string
+	// PrivateKeyFile is the path of the private key on the client.
+	// Formats that can, read the key from there if PrivateKey is not set.
+	PrivateKeyFile string
+}
+
+// privateKey returns the private key for formats that can't read it from a file.
+func (c ConfigFile) privateKey() string {
+	if c.PrivateKey == (wgtypes.Key{}) {
+		return PrivateKeyPlaceholder
+	}
+	return c.PrivateKey.String()
 }
 
 func Format(w io.Writer, wgConfig ConfigFile) error {
@@ -0,0 +1,302 @@
+package clientconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"sigs.k8s.io/yaml"
+)
+
+// Names of the built in formats.
+const (
+	FormatWGQuick        = "wg-quick"
+	FormatNetworkd       = "networkd"
+	FormatNetworkManager = "networkmanager"
+	FormatJSON           = "json"
+	FormatYAML           = "yaml"
+)
+
+// Interface is the name of the wireguard interface in formats that need one.
+// wg-quick takes it from the file name instead.
+const Interface = "wga0"
+
+// File is one file of a rendered config.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Formatter renders a config into the files a client needs, eg. a .netdev and .network pair for systemd-networkd.
+type Formatter func(cfg ConfigFile) ([]File, error)
+
+var formatters = map[string]Formatter{
+	FormatWGQuick:        formatWGQuick,
+	FormatNetworkd:       formatNetworkd,
+	FormatNetworkManager: formatNetworkManager,
+	FormatJSON:           formatJSON,
+	FormatYAML:           formatYAML,
+}
+
+// RegisterFormat adds a format or replaces a built in one. It is not safe to call concurrently with RenderFiles.
+func RegisterFormat(name string, f Formatter) {
+	formatters[name] = f
+}
+
+// Formats returns the names of all formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// RenderFiles renders a config in the named format.
+func RenderFiles(cfg ConfigFile, format string) ([]File, error) {
+	f, ok := formatters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown config format %q, expected one of %s", format, strings.Join(Formats(), ", "))
+	}
+
+	return f(cfg)
+}
+
+func formatWGQuick(cfg ConfigFile) ([]File, error) {
+	buf := &strings.Builder{}
+	err := Format(buf, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return []File{{Name: Interface + ".conf", Data: []byte(buf.String())}}, nil
+}
+
+// addresses returns the interface addresses of a config, split by family.
+func addresses(cfg ConfigFile) (v4, v6 []string) {
+	for _, addr := range strings.Split(cfg.Address, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+
+		ip, _, err := net.ParseCIDR(addr)
+		if err == nil && ip.To4() != nil {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+
+	return v4, v6
+}
+
+func formatNetworkd(cfg ConfigFile) ([]File, error) {
+	netdev := &strings.Builder{}
+	fmt.Fprintf(netdev, "[NetDev]\nName=%s\nKind=wireguard\n", Interface)
+	if cfg.Name != "" {
+		fmt.Fprintf(netdev, "Description=%s\n", cfg.Name)
+	}
+	if cfg.PrivateKey == (wgtypes.Key{}) && cfg.PrivateKeyFile != "" {
+		fmt.Fprintf(netdev, "\n[WireGuard]\nPrivateKeyFile=%s\n", cfg.PrivateKeyFile)
+	} else {
+		fmt.Fprintf(netdev, "\n[WireGuard]\nPrivateKey=%s\n", cfg.privateKey())
+	}
+
+	for _, p := range cfg.Peers {
+		fmt.Fprintf(netdev, "\n[WireGuardPeer]\nPublicKey=%s\n", p.PublicKey)
+		if p.PresharedKey != (wgtypes.Key{}) {
+			fmt.Fprintf(netdev, "PresharedKey=%s\n", p.PresharedKey)
+		}
+		if len(p.AllowedIPs) > 0 {
+			fmt.Fprintf(netdev, "AllowedIPs=%s\n", joinNets(p.AllowedIPs, ","))
+		}
+		if p.Endpoint != nil {
+			fmt.Fprintf(netdev, "Endpoint=%s\n", p.Endpoint)
+		}
+		if p.PersistentKeepaliveInterval > 0 {
+			fmt.Fprintf(netdev, "PersistentKeepalive=%d\n", int(p.PersistentKeepaliveInterval.Seconds()))
+		}
+	}
+
+	network := &strings.Builder{}
+	fmt.Fprintf(network, "[Match]\nName=%s\n\n[Network]\n", Interface)
+	v4, v6 := addresses(cfg)
+	for _, addr := range append(v6, v4...) {
+		fmt.Fprintf(network, "Address=%s\n", addr)
+	}
+	for _, dns := range cfg.DNS {
+		fmt.Fprintf(network, "DNS=%s\n", dns)
+	}
+
+	// networkd doesn't route the allowed ips by itself
+	for _, p := range cfg.Peers {
+		for _, ip := range p.AllowedIPs {
+			fmt.Fprintf(network, "\n[Route]\nDestination=%s\n", ip.String())
+		}
+	}
+
+	return []File{
+		{Name: Interface + ".netdev", Data: []byte(netdev.String())},
+		{Name: Interface + ".network", Data: []byte(network.String())},
+	}, nil
+}
+
+func formatNetworkManager(cfg ConfigFile) ([]File, error) {
+	id := cfg.Name
+	if id == "" {
+		id = Interface
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "[connection]\nid=%s\ntype=wireguard\ninterface-name=%s\n", id, Interface)
+	// NetworkManager can't read the key from a file
+	fmt.Fprintf(b, "\n[wireguard]\nprivate-key=%s\n", cfg.privateKey())
+
+	for _, p := range cfg.Peers {
+		fmt.Fprintf(b, "\n[wireguard-peer.%s]\n", p.PublicKey)
+		if p.Endpoint != nil {
+			fmt.Fprintf(b, "endpoint=%s\n", p.Endpoint)
+		}
+		if p.PresharedKey != (wgtypes.Key{}) {
+			fmt.Fprintf(b, "preshared-key=%s\npreshared-key-flags=0\n", p.PresharedKey)
+		}
+		if p.PersistentKeepaliveInterval > 0 {
+			fmt.Fprintf(b, "persistent-keepalive=%d\n", int(p.PersistentKeepaliveInterval.Seconds()))
+		}
+		if len(p.AllowedIPs) > 0 {
+			fmt.Fprintf(b, "allowed-ips=%s;\n", joinNets(p.AllowedIPs, ";"))
+		}
+	}
+
+	v4, v6 := addresses(cfg)
+	dns4, dns6 := []string{}, []string{}
+	for _, dns := range cfg.DNS {
+		if ip := net.ParseIP(dns); ip != nil && ip.To4() != nil {
+			dns4 = append(dns4, dns)
+		} else {
+			dns6 = append(dns6, dns)
+		}
+	}
+
+	ipSection := func(name string, addrs, dns []string) {
+		fmt.Fprintf(b, "\n[%s]\n", name)
+		if len(addrs) == 0 {
+			fmt.Fprintf(b, "method=disabled\n")
+			return
+		}
+
+		fmt.Fprintf(b, "method=manual\n")
+		for i, addr := range addrs {
+			fmt.Fprintf(b, "address%d=%s\n", i+1, addr)
+		}
+		if len(dns) > 0 {
+			fmt.Fprintf(b, "dns=%s;\n", strings.Join(dns, ";"))
+		}
+	}
+	ipSection("ipv4", v4, dns4)
+	ipSection("ipv6", v6, dns6)
+
+	return []File{{Name: id + ".nmconnection", Data: []byte(b.String())}}, nil
+}
+
+// structuredConfig is the json and yaml form of a config.
+type structuredConfig struct {
+	Name      string           `json:"name,omitempty"`
+	Interface structuredIface  `json:"interface"`
+	Peers     []structuredPeer `json:"peers"`
+}
+
+type structuredIface struct {
+	PrivateKey     string   `json:"privateKey,omitempty"`
+	PrivateKeyFile string   `json:"privateKeyFile,omitempty"`
+	Addresses      []string `json:"addresses"`
+	DNS            []string `json:"dns,omitempty"`
+}
+
+type structuredPeer struct {
+	PublicKey           string   `json:"publicKey"`
+	PresharedKey        string   `json:"presharedKey,omitempty"`
+	Endpoint            string   `json:"endpoint,omitempty"`
+	AllowedIPs          []string `json:"allowedIPs"`
+	PersistentKeepalive int      `json:"persistentKeepalive,omitempty"`
+}
+
+func structured(cfg ConfigFile) structuredConfig {
+	v4, v6 := addresses(cfg)
+	s := structuredConfig{
+		Name: cfg.Name,
+		Interface: structuredIface{
+			Addresses: append(v6, v4...),
+			DNS:       cfg.DNS,
+		},
+		Peers: []structuredPeer{},
+	}
+	if cfg.PrivateKey != (wgtypes.Key{}) {
+		s.Interface.PrivateKey = cfg.PrivateKey.String()
+	} else if cfg.PrivateKeyFile != "" {
+		s.Interface.PrivateKeyFile = cfg.PrivateKeyFile
+	} else {
+		s.Interface.PrivateKey = PrivateKeyPlaceholder
+	}
+
+	for _, p := range cfg.Peers {
+		sp := structuredPeer{
+			PublicKey:           p.PublicKey.String(),
+			AllowedIPs:          []string{},
+			PersistentKeepalive: int(p.PersistentKeepaliveInterval / time.Second),
+		}
+		if p.PresharedKey != (wgtypes.Key{}) {
+			sp.PresharedKey = p.PresharedKey.String()
+		}
+		if p.Endpoint != nil {
+			sp.Endpoint = p.Endpoint.String()
+		}
+		for _, ip := range p.AllowedIPs {
+			sp.AllowedIPs = append(sp.AllowedIPs, ip.String())
+		}
+		s.Peers = append(s.Peers, sp)
+	}
+
+	return s
+}
+
+func formatJSON(cfg ConfigFile) ([]File, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "  ")
+	// keeps the placeholder readable
+	enc.SetEscapeHTML(false)
+
+	err := enc.Encode(structured(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return []File{{Name: Interface + ".json", Data: buf.Bytes()}}, nil
+}
+
+func formatYAML(cfg ConfigFile) ([]File, error) {
+	data, err := yaml.Marshal(structured(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	return []File{{Name: Interface + ".yaml", Data: data}}, nil
+}
+
+func joinNets(nets []net.IPNet, sep string) string {
+	strs := make([]string, 0, len(nets))
+	for _, n := range nets {
+		strs = append(strs, n.String())
+	}
+
+	return strings.Join(strs, sep)
+}
@@ -0,0 +1,247 @@
+package clientconfig
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	testPrivateKey = "AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	testPublicKey  = "AgAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	testPSK        = "AwAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+)
+
+// testConfig is a dual stack config with one peer routing two networks.
+func testConfig(t *testing.T) ConfigFile {
+	t.Helper()
+
+	nets := []net.IPNet{}
+	for _, s := range []string{"fd00:2::/64", "10.2.0.0/16"} {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, *n)
+	}
+
+	return ConfigFile{
+		Name:    "alice",
+		Address: "fd00:1::1/128,10.1.0.1/32",
+		DNS:     []string{"fd00::53", "10.0.0.53"},
+		Device: wgtypes.Device{
+			PrivateKey: wgtypes.Key{1},
+			Peers: []wgtypes.Peer{{
+				PublicKey:                   wgtypes.Key{2},
+				PresharedKey:                wgtypes.Key{3},
+				Endpoint:                    &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51820},
+				AllowedIPs:                  nets,
+				PersistentKeepaliveInterval: 60 * time.Second,
+			}},
+		},
+	}
+}
+
+func TestRenderFiles(t *testing.T) {
+	wgQuick := `# alice
+[Interface]
+PrivateKey = ` + testPrivateKey + `
+Address = fd00:1::1/128,10.1.0.1/32
+DNS = fd00::53, 10.0.0.53
+
+[Peer]
+Endpoint = [2001:db8::1]:51820
+PublicKey = ` + testPublicKey + `
+PresharedKey = ` + testPSK + `
+AllowedIPs = fd00:2::/64, 10.2.0.0/16
+PersistentKeepalive = 60
+`
+
+	netdev := `[NetDev]
+Name=wga0
+Kind=wireguard
+Description=alice
+
+[WireGuard]
+PrivateKey=` + testPrivateKey + `
+
+[WireGuardPeer]
+PublicKey=` + testPublicKey + `
+PresharedKey=` + testPSK + `
+AllowedIPs=fd00:2::/64,10.2.0.0/16
+Endpoint=[2001:db8::1]:51820
+PersistentKeepalive=60
+`
+
+	network := `[Match]
+Name=wga0
+
+[Network]
+Address=fd00:1::1/128
+Address=10.1.0.1/32
+DNS=fd00::53
+DNS=10.0.0.53
+
+[Route]
+Destination=fd00:2::/64
+
+[Route]
+Destination=10.2.0.0/16
+`
+
+	nmconnection := `[connection]
+id=alice
+type=wireguard
+interface-name=wga0
+
+[wireguard]
+private-key=` + testPrivateKey + `
+
+[wireguard-peer.` + testPublicKey + `]
+endpoint=[2001:db8::1]:51820
+preshared-key=` + testPSK + `
+preshared-key-flags=0
+persistent-keepalive=60
+allowed-ips=fd00:2::/64;10.2.0.0/16;
+
+[ipv4]
+method=manual
+address1=10.1.0.1/32
+dns=10.0.0.53;
+
+[ipv6]
+method=manual
+address1=fd00:1::1/128
+dns=fd00::53;
+`
+
+	json := `{
+  "name": "alice",
+  "interface": {
+    "privateKey": "` + testPrivateKey + `",
+    "addresses": [
+      "fd00:1::1/128",
+      "10.1.0.1/32"
+    ],
+    "dns": [
+      "fd00::53",
+      "10.0.0.53"
+    ]
+  },
+  "peers": [
+    {
+      "publicKey": "` + testPublicKey + `",
+      "presharedKey": "` + testPSK + `",
+      "endpoint": "[2001:db8::1]:51820",
+      "allowedIPs": [
+        "fd00:2::/64",
+        "10.2.0.0/16"
+      ],
+      "persistentKeepalive": 60
+    }
+  ]
+}
+`
+
+	yaml := `interface:
+  addresses:
+  - fd00:1::1/128
+  - 10.1.0.1/32
+  dns:
+  - fd00::53
+  - 10.0.0.53
+  privateKey: ` + testPrivateKey + `
+name: alice
+peers:
+- allowedIPs:
+  - fd00:2::/64
+  - 10.2.0.0/16
+  endpoint: '[2001:db8::1]:51820'
+  persistentKeepalive: 60
+  presharedKey: ` + testPSK + `
+  publicKey: ` + testPublicKey + `
+`
+
+	// the private key never left the client
+	placeholder := func(cfg *ConfigFile) {
+		cfg.PrivateKey = wgtypes.Key{}
+	}
+	keyFile := func(cfg *ConfigFile) {
+		cfg.PrivateKey = wgtypes.Key{}
+		cfg.PrivateKeyFile = "/etc/wireguard/wga0.key"
+	}
+
+	for _, tc := range []struct {
+		name   string
+		format string
+		modify func(*ConfigFile)
+		files  map[string]string
+	}{
+		{"wg-quick", FormatWGQuick, nil, map[string]string{"wga0.conf": wgQuick}},
+		{"networkd", FormatNetworkd, nil, map[string]string{"wga0.netdev": netdev, "wga0.network": network}},
+		{"networkmanager", FormatNetworkManager, nil, map[string]string{"alice.nmconnection": nmconnection}},
+		{"json", FormatJSON, nil, map[string]string{"wga0.json": json}},
+		{"yaml", FormatYAML, nil, map[string]string{"wga0.yaml": yaml}},
+
+		{"wg-quick placeholder", FormatWGQuick, placeholder, map[string]string{
+			"wga0.conf": strings.Replace(wgQuick, "PrivateKey = "+testPrivateKey, "PrivateKey = "+PrivateKeyPlaceholder, 1),
+		}},
+		{"wg-quick key file", FormatWGQuick, keyFile, map[string]string{
+			"wga0.conf": strings.Replace(wgQuick, "PrivateKey = "+testPrivateKey, "PostUp = wg set %i private-key /etc/wireguard/wga0.key", 1),
+		}},
+		{"networkd placeholder", FormatNetworkd, placeholder, map[string]string{
+			"wga0.netdev":  strings.Replace(netdev, "PrivateKey="+testPrivateKey, "PrivateKey="+PrivateKeyPlaceholder, 1),
+			"wga0.network": network,
+		}},
+		{"networkd key file", FormatNetworkd, keyFile, map[string]string{
+			"wga0.netdev":  strings.Replace(netdev, "PrivateKey="+testPrivateKey, "PrivateKeyFile=/etc/wireguard/wga0.key", 1),
+			"wga0.network": network,
+		}},
+		// NetworkManager can't read the key from a file
+		{"networkmanager key file", FormatNetworkManager, keyFile, map[string]string{
+			"alice.nmconnection": strings.Replace(nmconnection, "private-key="+testPrivateKey, "private-key="+PrivateKeyPlaceholder, 1),
+		}},
+		{"json placeholder", FormatJSON, placeholder, map[string]string{
+			"wga0.json": strings.Replace(json, `"privateKey": "`+testPrivateKey+`"`, `"privateKey": "`+PrivateKeyPlaceholder+`"`, 1),
+		}},
+		{"yaml key file", FormatYAML, keyFile, map[string]string{
+			"wga0.yaml": strings.Replace(yaml, "privateKey: "+testPrivateKey, "privateKeyFile: /etc/wireguard/wga0.key", 1),
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			if tc.modify != nil {
+				tc.modify(&cfg)
+			}
+
+			files, err := RenderFiles(cfg, tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(files) != len(tc.files) {
+				t.Errorf("expected %d files, got %d", len(tc.files), len(files))
+			}
+			for _, f := range files {
+				want, ok := tc.files[f.Name]
+				if !ok {
+					t.Errorf("unexpected file %s", f.Name)
+					continue
+				}
+				if string(f.Data) != want {
+					t.Errorf("%s:\n%s\nexpected:\n%s", f.Name, f.Data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderFilesUnknownFormat(t *testing.T) {
+	_, err := RenderFiles(testConfig(t), "ini")
+	if err == nil || !strings.Contains(err.Error(), FormatWGQuick) {
+		t.Errorf("expected an error listing the formats, got %v", err)
+	}
+}
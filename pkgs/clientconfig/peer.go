@@ -0,0 +1,93 @@
+package clientconfig
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/kraudcloud/wga/pkgs/apis/v1beta"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// FromPeer builds the wg-quick config of a WireguardAccessPeer from its status.
+// psk is used for server peers that don't carry their own preshared key.
+func FromPeer(peer v1beta.WireguardAccessPeer, pk, psk wgtypes.Key) (ConfigFile, error) {
+	if peer.Status == nil {
+		return ConfigFile{}, fmt.Errorf("peer %s has no status yet", peer.Name)
+	}
+
+	peers := []wgtypes.Peer{}
+	for _, peer := range peer.Status.Peers {
+		publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+		if err != nil {
+			return ConfigFile{}, fmt.Errorf("cannot parse public key: %w", err)
+		}
+
+		ips := []net.IPNet{}
+		for _, ip := range peer.AllowedIPs {
+			_, cidr, err := net.ParseCIDR(ip)
+			if err != nil {
+				return ConfigFile{}, fmt.Errorf("cannot parse allowed ip: %w", err)
+			}
+
+			ips = append(ips, *cidr)
+		}
+
+		endpoint, err := netip.ParseAddrPort(peer.Endpoint)
+		if err != nil {
+			return ConfigFile{}, fmt.Errorf("cannot parse endpoint: %w", err)
+		}
+
+		peerPSK := psk
+		if len(peer.PreSharedKey) != 0 {
+			peerPSK, err = wgtypes.ParseKey(peer.PreSharedKey)
+			if err != nil {
+				return ConfigFile{}, fmt.Errorf("cannot parse preshared key: %w", err)
+			}
+		}
+
+		peers = append(peers, wgtypes.Peer{
+			PublicKey:                   publicKey,
+			AllowedIPs:                  ips,
+			Endpoint:                    net.UDPAddrFromAddrPort(endpoint),
+			PresharedKey:                peerPSK,
+			PersistentKeepaliveInterval: time.Second * 60,
+		})
+	}
+
+	addresses := peer.Status.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{peer.Status.Address}
+	}
+
+	ips := []string{}
+	for _, addr := range addresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return ConfigFile{}, fmt.Errorf("cannot parse address %q", addr)
+		}
+
+		ipn := net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(128, 128),
+		}
+		if ip.To4() != nil {
+			ipn.Mask = net.CIDRMask(32, 32)
+		}
+		ips = append(ips, ipn.String())
+	}
+
+	return ConfigFile{
+		Name:    peer.Name,
+		Address: strings.Join(ips, ","),
+		DNS:     peer.Status.DNS,
+		Device: wgtypes.Device{
+			Name:       peer.Name,
+			PrivateKey: pk,
+			ListenPort: 51820,
+			Peers:      peers,
+		},
+	}, nil
+}